@@ -1,25 +1,22 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
 )
 
 func main() {
-	n := maelstrom.NewNode()
+	if err := run(maelstrom.NewNode()); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	n.Handle("echo", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-		body["type"] = "echo_ok"
-		return n.Reply(msg, body)
+func run(n *maelstrom.Node) error {
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Echo) error {
+		return proto.Reply(n, msg, proto.EchoOk{Echo: req.Echo})
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return proto.Run(n)
 }
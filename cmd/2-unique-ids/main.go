@@ -1,30 +1,27 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
 )
 
 func main() {
-	n := maelstrom.NewNode()
+	if err := run(maelstrom.NewNode()); err != nil {
+		log.Fatal(err)
+	}
+}
 
+func run(n *maelstrom.Node) error {
 	id := 0
 
-	n.Handle("generate", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		b := map[string]any{"type": "generate_ok", "id": fmt.Sprintf("%d%s", id, n.ID())}
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Generate) error {
+		b := proto.GenerateOk{ID: fmt.Sprintf("%d%s", id, n.ID())}
 		id += 1
-		return n.Reply(msg, b)
+		return proto.Reply(n, msg, b)
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return proto.Run(n)
 }
@@ -1,49 +1,41 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"slices"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
 )
 
 func main() {
-	n := maelstrom.NewNode()
+	if err := run(maelstrom.NewNode()); err != nil {
+		log.Fatal(err)
+	}
+}
 
+func run(n *maelstrom.Node) error {
 	var ids []int
 	var idsMu sync.RWMutex
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
 		idsMu.Lock()
-		ids = append(ids, int(body["message"].(float64)))
+		ids = append(ids, req.Message)
 		idsMu.Unlock()
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+		return proto.Reply(n, msg, proto.BroadcastOk{})
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
 		idsMu.RLock()
-		b := map[string]any{"type": "read_ok", "messages": slices.Clone(ids)}
+		b := proto.ReadMessagesOk{Messages: slices.Clone(ids)}
 		idsMu.RUnlock()
-		return n.Reply(msg, b)
+		return proto.Reply(n, msg, b)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		return n.Reply(msg, map[string]any{"type": "topology_ok"})
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Topology) error {
+		return proto.Reply(n, msg, proto.TopologyOk{})
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return proto.Run(n)
 }
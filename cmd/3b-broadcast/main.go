@@ -1,68 +1,57 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
 )
 
-type topologyMsg struct {
-	Topology map[string][]string `json:"topology"`
-}
-
 func main() {
-	n := maelstrom.NewNode()
+	topo, err := topology.FromFlags("maelstrom")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(maelstrom.NewNode(), topo); err != nil {
+		log.Fatal(err)
+	}
+}
 
+func run(n *maelstrom.Node, topo topology.Strategy) error {
 	ids := make(map[int]struct{})
 	var idsMu sync.RWMutex
 
 	var neighbors []string
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		id := int(body["message"].(float64))
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
 		idsMu.Lock()
-		if _, ok := ids[id]; !ok {
-			ids[id] = struct{}{}
+		if _, ok := ids[req.Message]; !ok {
+			ids[req.Message] = struct{}{}
 			for _, nbor := range neighbors {
-				n.Send(nbor, body)
+				proto.Notify(n, nbor, req)
 			}
 		}
 		idsMu.Unlock()
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+		return proto.Reply(n, msg, proto.BroadcastOk{})
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
 		idsMu.RLock()
-		b := map[string]any{"type": "read_ok", "messages": sliceFromSet(ids)}
+		b := proto.ReadMessagesOk{Messages: sliceFromSet(ids)}
 		idsMu.RUnlock()
-		return n.Reply(msg, b)
+		return proto.Reply(n, msg, b)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		var t topologyMsg
-		if err := json.Unmarshal(msg.Body, &t); err != nil {
-			return err
-		}
-
-		neighbors = t.Topology[n.ID()]
-		return n.Reply(msg, map[string]any{"type": "topology_ok"})
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Topology) error {
+		idsMu.Lock()
+		neighbors = topo(n.NodeIDs(), req.Topology)[n.ID()]
+		idsMu.Unlock()
+		return proto.Reply(n, msg, proto.TopologyOk{})
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return proto.Run(n)
 }
 
 func sliceFromSet[T comparable](set map[T]struct{}) []T {
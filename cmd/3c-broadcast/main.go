@@ -1,101 +1,103 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"log"
 	"sync"
-	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/antientropy"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/outbox"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+	"github.com/toxeeec/gossip-glomers/internal/swim"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
 )
 
-type topologyMsg struct {
-	Topology map[string][]string `json:"topology"`
-}
-
-type broadcastMsg struct {
-	dest string
-	body map[string]any
-}
-
 func main() {
-	n := maelstrom.NewNode()
+	topo, err := topology.FromFlags("maelstrom")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(maelstrom.NewNode(), clock.Real(), topo); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	ids := make(map[float64]struct{})
+func run(n *maelstrom.Node, clk clock.Clock, topo topology.Strategy) error {
+	var ids idset.Set
 	var idsMu sync.RWMutex
 
-	var neighbors []string
+	// Anti-entropy asks for the neighbours from its own goroutine, so they
+	// are read and replaced under topoMu.
+	var static []string
+	var topoMu sync.RWMutex
+	neighbors := func() []string {
+		topoMu.RLock()
+		defer topoMu.RUnlock()
+		return static
+	}
 
-	msgs := make(chan broadcastMsg)
+	members := swim.Start(n, clk, swim.Options{})
 
-	go func() {
-		for msg := range msgs {
-			go func(msg broadcastMsg) {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
-				_, err := n.SyncRPC(ctx, msg.dest, msg.body)
-				if err != nil {
-					msgs <- msg
-				}
-			}(msg)
-		}
-	}()
+	ob := outbox.New(n, clk, outbox.Options{MaxAttempts: 3, RTT: rtt.New(clk, rtt.Options{})})
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+	// add records id and forwards it to every reachable neighbour but src.
+	// Anti-entropy catches up the others once they are reachable again.
+	// idsMu must be held.
+	add := func(id int, src string) {
+		if !ids.Add(id) {
+			return
 		}
-
-		id := body["message"].(float64)
-		idsMu.Lock()
-		if _, ok := ids[id]; !ok {
-			ids[id] = struct{}{}
-			for _, nbor := range neighbors {
-				if nbor == msg.Src {
-					continue
-				}
-				msgs <- broadcastMsg{nbor, body}
+		for _, nbor := range neighbors() {
+			if nbor == src || !members.Reachable(nbor) {
+				continue
 			}
+			ob.Send(nbor, proto.Broadcast{Message: id})
 		}
-		idsMu.Unlock()
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+	}
+
+	ae := antientropy.Start(n, clk, antientropy.Options{
+		Peers: func() []string { return members.Filter(neighbors()) },
+		IDs: func() []int {
+			idsMu.RLock()
+			defer idsMu.RUnlock()
+			return ids.Slice()
+		},
+		Add: func(missing []int) {
+			idsMu.Lock()
+			for _, id := range missing {
+				add(id, "")
+			}
+			idsMu.Unlock()
+		},
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		idsMu.Lock()
+		add(req.Message, msg.Src)
+		idsMu.Unlock()
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
 
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
 		idsMu.RLock()
-		b := map[string]any{"type": "read_ok", "messages": sliceFromSet(ids)}
+		b := proto.ReadMessagesOk{Messages: ids.Slice()}
 		idsMu.RUnlock()
-		return n.Reply(msg, b)
+		return proto.Reply(n, msg, b)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		var t topologyMsg
-		if err := json.Unmarshal(msg.Body, &t); err != nil {
-			return err
-		}
-
-		neighbors = t.Topology[n.ID()]
-		return n.Reply(msg, map[string]any{"type": "topology_ok"})
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Topology) error {
+		topoMu.Lock()
+		static = topo(n.NodeIDs(), req.Topology)[n.ID()]
+		topoMu.Unlock()
+		return proto.Reply(n, msg, proto.TopologyOk{})
 	})
 
-	err := n.Run()
-	close(msgs)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func sliceFromSet[T comparable](set map[T]struct{}) []T {
-	s := make([]T, 0, len(set))
-	for k := range set {
-		s = append(s, k)
-	}
-	return s
+	err := proto.Run(n)
+	members.Stop()
+	ae.Stop()
+	ob.Close()
+	return err
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/simtest"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+func TestPartition(t *testing.T) {
+	simtest.Run(t, sim.Config{}, 5, func(n *maelstrom.Node, clk clock.Clock) error {
+		return run(n, clk, topology.Maelstrom)
+	}, workload.Options{Workload: "broadcast", TimeLimit: 12 * time.Second, Rate: 10, Partition: true})
+}
+
+func TestDeterministic(t *testing.T) {
+	trace := func() []byte {
+		var buf bytes.Buffer
+		simtest.Run(t, sim.Config{Latency: 10 * time.Millisecond, DropRate: 0.05, Seed: 3, Deterministic: true, Trace: &buf}, 5, func(n *maelstrom.Node, clk clock.Clock) error {
+			return run(n, clk, topology.Maelstrom)
+		}, workload.Options{Workload: "broadcast", TimeLimit: 12 * time.Second, Rate: 10, Partition: true, Seed: 3})
+		return buf.Bytes()
+	}
+
+	first, second := trace(), trace()
+	if !bytes.Equal(first, second) {
+		a, b := bytes.Split(first, []byte("\n")), bytes.Split(second, []byte("\n"))
+		for i := range min(len(a), len(b)) {
+			if !bytes.Equal(a[i], b[i]) {
+				t.Fatalf("runs with the same seed diverged at message %d:\n%s\n%s", i, a[i], b[i])
+			}
+		}
+		t.Fatalf("runs with the same seed delivered %d and %d messages", len(a)-1, len(b)-1)
+	}
+}
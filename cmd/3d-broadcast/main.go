@@ -1,107 +1,126 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
+	"flag"
 	"log"
-	"strconv"
+	"os"
 	"sync"
-	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/antientropy"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/outbox"
+	"github.com/toxeeec/gossip-glomers/internal/overlay"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+	"github.com/toxeeec/gossip-glomers/internal/swim"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
 )
 
-type broadcastMsg struct {
-	dest string
-	body map[string]any
-}
-
 func main() {
-	n := maelstrom.NewNode()
+	latencyTree := flag.Bool("latency-tree", false, "replace the topology with a tree built from measured round trip times ($GLOMERS_LATENCY_TREE)")
+	if v := os.Getenv("GLOMERS_LATENCY_TREE"); v != "" {
+		if err := flag.Set("latency-tree", v); err != nil {
+			log.Fatalf("GLOMERS_LATENCY_TREE: %v", err)
+		}
+	}
+	topo, err := topology.FromFlags("tree:4")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(maelstrom.NewNode(), clock.Real(), topo, *latencyTree); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	ids := make(map[float64]struct{})
+func run(n *maelstrom.Node, clk clock.Clock, topo topology.Strategy, latencyTree bool) error {
+	var ids idset.Set
 	var idsMu sync.RWMutex
 
-	var neighbors []string
-
-	msgs := make(chan broadcastMsg)
+	members := swim.Start(n, clk, swim.Options{})
 
-	go func() {
-		for msg := range msgs {
-			go func(msg broadcastMsg) {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
-				_, err := n.SyncRPC(ctx, msg.dest, msg.body)
-				if err != nil {
-					msgs <- msg
-				}
-			}(msg)
+	// neighbors are the ones of the latency tree once it is agreed on, if
+	// it is enabled, and the ones given by topo until then. Nodes cut off
+	// by unreachable ones are re-attached to the tree until they heal.
+	var static map[string][]string
+	var topoMu sync.RWMutex
+	var ov *overlay.Overlay
+	if latencyTree {
+		ov = overlay.Start(n, clk, overlay.Options{})
+	}
+	neighbors := func() []string {
+		topoMu.RLock()
+		tree := static
+		topoMu.RUnlock()
+		if ov != nil {
+			if t, ok := ov.Tree(); ok {
+				tree = t
+			}
 		}
-	}()
+		return topology.Heal(n.NodeIDs(), tree, members.Reachable)[n.ID()]
+	}
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
+	ob := outbox.New(n, clk, outbox.Options{MaxAttempts: 3, RTT: rtt.New(clk, rtt.Options{})})
 
-		id := body["message"].(float64)
-		idsMu.Lock()
-		if _, ok := ids[id]; !ok {
-			ids[id] = struct{}{}
-			for _, nbor := range neighbors {
-				if nbor == msg.Src {
-					continue
-				}
-				msgs <- broadcastMsg{nbor, body}
+	// add records id and forwards it to every reachable neighbour but src.
+	// Anti-entropy catches up the others once they are reachable again.
+	// idsMu must be held.
+	add := func(id int, src string) {
+		if !ids.Add(id) {
+			return
+		}
+		for _, nbor := range neighbors() {
+			if nbor == src || !members.Reachable(nbor) {
+				continue
 			}
+			ob.Send(nbor, proto.Broadcast{Message: id})
 		}
-		idsMu.Unlock()
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+	}
+
+	ae := antientropy.Start(n, clk, antientropy.Options{
+		Peers: func() []string { return members.Filter(neighbors()) },
+		IDs: func() []int {
+			idsMu.RLock()
+			defer idsMu.RUnlock()
+			return ids.Slice()
+		},
+		Add: func(missing []int) {
+			idsMu.Lock()
+			for _, id := range missing {
+				add(id, "")
+			}
+			idsMu.Unlock()
+		},
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		idsMu.Lock()
+		add(req.Message, msg.Src)
+		idsMu.Unlock()
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
 
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
 		idsMu.RLock()
-		b := map[string]any{"type": "read_ok", "messages": sliceFromSet(ids)}
+		b := proto.ReadMessagesOk{Messages: ids.Slice()}
 		idsMu.RUnlock()
-		return n.Reply(msg, b)
+		return proto.Reply(n, msg, b)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		neighbors = getNeighbors(n.ID(), n.NodeIDs())
-		return n.Reply(msg, map[string]any{"type": "topology_ok"})
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Topology) error {
+		topoMu.Lock()
+		static = topo(n.NodeIDs(), req.Topology)
+		topoMu.Unlock()
+		return proto.Reply(n, msg, proto.TopologyOk{})
 	})
 
-	err := n.Run()
-	close(msgs)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func getNeighbors(node string, nodes []string) []string {
-	nodeIndex, _ := strconv.Atoi(node[1:])
-	neighbors := make([]string, 0, 5)
-	parentIndex := (nodeIndex - 1) / 4
-	if parentIndex != nodeIndex {
-		neighbors = append(neighbors, nodes[parentIndex])
-	}
-	childIndex := nodeIndex * 4
-	for i := 1; i <= 4 && childIndex+i < len(nodes); i++ {
-		neighbors = append(neighbors, nodes[childIndex+i])
-	}
-	return neighbors
-}
-
-func sliceFromSet[T comparable](set map[T]struct{}) []T {
-	s := make([]T, 0, len(set))
-	for k := range set {
-		s = append(s, k)
+	err := proto.Run(n)
+	if ov != nil {
+		ov.Stop()
 	}
-	return s
+	members.Stop()
+	ae.Stop()
+	ob.Close()
+	return err
 }
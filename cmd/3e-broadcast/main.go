@@ -2,129 +2,252 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"log"
+	"os"
 	"slices"
-	"strconv"
 	"sync"
 	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/antientropy"
+	"github.com/toxeeec/gossip-glomers/internal/batch"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/overlay"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+	"github.com/toxeeec/gossip-glomers/internal/swim"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
 )
 
-type broadcastMsg struct {
-	dest string
-	body map[string]any
+// gossip carries the messages of the sender from index From on, in the order
+// the sender learned them.
+type gossip struct {
+	From     int   `json:"from"`
+	Messages []int `json:"messages"`
 }
 
+// gossipOk acknowledges every message of the sender before index Acked.
+type gossipOk struct {
+	Acked int `json:"acked"`
+}
+
+// cursor tracks how many of a node's messages were sent to a neighbour and
+// how many the neighbour acknowledged.
+type cursor struct {
+	sent, acked int
+}
+
+func (gossip) Type() string   { return "gossip" }
+func (gossipOk) Type() string { return "gossip_ok" }
+
 func main() {
-	n := maelstrom.NewNode()
+	var opts batch.Options
+	flag.IntVar(&opts.Size, "batch-size", 32, "number of messages that flushes a batch to a neighbour ($GLOMERS_BATCH_SIZE)")
+	flag.DurationVar(&opts.MinInterval, "min-interval", 20*time.Millisecond, "shortest time between flushes ($GLOMERS_MIN_INTERVAL)")
+	flag.DurationVar(&opts.MaxInterval, "max-interval", 500*time.Millisecond, "longest time between flushes ($GLOMERS_MAX_INTERVAL)")
+	flag.Float64Var(&opts.RTTFraction, "rtt-fraction", 0.5, "cap on the time between flushes, as a fraction of the round trip time ($GLOMERS_RTT_FRACTION)")
+	latencyTree := flag.Bool("latency-tree", false, "replace the topology with a tree built from measured round trip times ($GLOMERS_LATENCY_TREE)")
+	for name, env := range map[string]string{
+		"batch-size":   "GLOMERS_BATCH_SIZE",
+		"min-interval": "GLOMERS_MIN_INTERVAL",
+		"max-interval": "GLOMERS_MAX_INTERVAL",
+		"rtt-fraction": "GLOMERS_RTT_FRACTION",
+		"latency-tree": "GLOMERS_LATENCY_TREE",
+	} {
+		if v := os.Getenv(env); v != "" {
+			if err := flag.Set(name, v); err != nil {
+				log.Fatalf("%s: %v", env, err)
+			}
+		}
+	}
+	topo, err := topology.FromFlags("tree:4")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(maelstrom.NewNode(), clock.Real(), topo, opts, *latencyTree); err != nil {
+		log.Fatal(err)
+	}
+}
 
+func run(n *maelstrom.Node, clk clock.Clock, topo topology.Strategy, opts batch.Options, latencyTree bool) error {
+	// ids lists the messages in the order they were learned, which the
+	// cursors index into, and seen holds the same messages as a set.
 	var ids []int
+	var seen idset.Set
 	var idsMu sync.RWMutex
-	prevIdx := -1
-
-	var neighbors []string
-
-	msgs := make(chan broadcastMsg)
 
-	ticker := time.NewTicker(100 * time.Millisecond)
+	members := swim.Start(n, clk, swim.Options{})
 
-	go func() {
-		for range ticker.C {
-			if prevIdx+1 == len(ids) {
-				continue
-			}
-			idsMu.RLock()
-			for _, nbor := range neighbors {
-				msgs <- broadcastMsg{nbor, map[string]any{"type": "broadcast", "message": ids[prevIdx+1:]}}
+	// neighbors are the ones of the latency tree once it is agreed on, if
+	// it is enabled, and the ones given by topo until then. Nodes cut off
+	// by unreachable ones are re-attached to the tree until they heal.
+	var static map[string][]string
+	var topoMu sync.RWMutex
+	var ov *overlay.Overlay
+	if latencyTree {
+		ov = overlay.Start(n, clk, overlay.Options{})
+	}
+	neighbors := func() []string {
+		topoMu.RLock()
+		tree := static
+		topoMu.RUnlock()
+		if ov != nil {
+			if t, ok := ov.Tree(); ok {
+				tree = t
 			}
-			prevIdx = len(ids) - 1
-			idsMu.RUnlock()
 		}
-	}()
-
-	go func() {
-		for msg := range msgs {
-			go func(msg broadcastMsg) {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
-				_, err := n.SyncRPC(ctx, msg.dest, msg.body)
-				if err != nil {
-					msgs <- msg
+		return topology.Heal(n.NodeIDs(), tree, members.Reachable)[n.ID()]
+	}
+	cursors := make(map[string]*cursor)
+	// received counts the messages of every neighbour received without gaps.
+	received := make(map[string]int)
+	var inflight sync.WaitGroup
+	rtts := rtt.New(clk, rtt.Options{})
+
+	// A neighbour's batch is flushed when it is full or when the interval
+	// of the policy has passed since the last flush.
+	policy := batch.New(clk, opts)
+	full := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	// learn appends id to the log if it is new, and requests a flush once a
+	// reachable neighbour has a full batch waiting. idsMu must be held.
+	learn := func(id int) {
+		if !seen.Add(id) {
+			return
+		}
+		ids = append(ids, id)
+		policy.Add(1)
+		for _, nbor := range neighbors() {
+			if c, ok := cursors[nbor]; ok && policy.Full(len(ids)-c.sent) && members.Reachable(nbor) {
+				select {
+				case full <- struct{}{}:
+				default:
 				}
-			}(msg)
+				return
+			}
 		}
-	}()
+	}
 
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
+	ae := antientropy.Start(n, clk, antientropy.Options{
+		Peers: func() []string { return members.Filter(neighbors()) },
+		IDs: func() []int {
+			idsMu.RLock()
+			defer idsMu.RUnlock()
+			return seen.Slice()
+		},
+		Add: func(missing []int) {
+			idsMu.Lock()
+			for _, id := range missing {
+				learn(id)
+			}
+			idsMu.Unlock()
+		},
+	})
+
+	ticker := clk.NewTicker(policy.Interval())
+
+	// send delivers a batch to nbor. If the batch is lost or the neighbour
+	// is missing an earlier one, everything after the last acknowledged
+	// message is sent again on the next tick.
+	send := func(nbor string, c *cursor, req gossip) {
+		defer inflight.Done()
+		start := clk.Now()
+		resp, err := rtt.RPC[gossipOk](context.Background(), rtts, n, nbor, req)
 
-		message := body["message"]
 		idsMu.Lock()
-		switch message := message.(type) {
-		case float64:
-			if slices.Index(ids, int(message)) == -1 {
-				ids = append(ids, int(message))
+		defer idsMu.Unlock()
+		if err == nil {
+			policy.ObserveRTT(clk.Now().Sub(start))
+			c.acked = max(c.acked, resp.Acked)
+		}
+		if err != nil || resp.Acked < req.From+len(req.Messages) {
+			c.sent = min(c.sent, c.acked)
+		}
+	}
+
+	inflight.Add(1)
+	go func() {
+		defer inflight.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+			case <-full:
 			}
-		case []any:
-			for _, id := range castSlice[float64](message) {
-				id := int(id)
-				if slices.Index(ids, id) == -1 {
-					ids = append(ids, id)
+			if !proto.Initialized(n) {
+				continue
+			}
+
+			idsMu.Lock()
+			for _, nbor := range neighbors() {
+				// Unreachable neighbours keep their cursors and are caught
+				// up once they are reachable again.
+				if !members.Reachable(nbor) {
+					continue
+				}
+				c, ok := cursors[nbor]
+				if !ok {
+					c = &cursor{}
+					cursors[nbor] = c
 				}
+				if c.sent == len(ids) {
+					continue
+				}
+				inflight.Add(1)
+				go send(nbor, c, gossip{From: c.sent, Messages: slices.Clone(ids[c.sent:])})
+				c.sent = len(ids)
 			}
+			idsMu.Unlock()
+			ticker.Reset(policy.Interval())
 		}
+	}()
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		idsMu.Lock()
+		learn(req.Message)
 		idsMu.Unlock()
-		return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+		return proto.Reply(n, msg, proto.BroadcastOk{})
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
+	proto.Handle(n, func(msg maelstrom.Message, req gossip) error {
+		idsMu.Lock()
+		for _, id := range req.Messages {
+			learn(id)
+		}
+		if req.From <= received[msg.Src] {
+			received[msg.Src] = max(received[msg.Src], req.From+len(req.Messages))
 		}
+		acked := received[msg.Src]
+		idsMu.Unlock()
+		return proto.Reply(n, msg, gossipOk{Acked: acked})
+	})
 
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
 		idsMu.RLock()
-		b := map[string]any{"type": "read_ok", "messages": slices.Clone(ids)}
+		b := proto.ReadMessagesOk{Messages: seen.Slice()}
 		idsMu.RUnlock()
-		return n.Reply(msg, b)
+		return proto.Reply(n, msg, b)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
-		neighbors = getNeighbors(n.ID(), n.NodeIDs())
-		return n.Reply(msg, map[string]any{"type": "topology_ok"})
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Topology) error {
+		topoMu.Lock()
+		static = topo(n.NodeIDs(), req.Topology)
+		topoMu.Unlock()
+		return proto.Reply(n, msg, proto.TopologyOk{})
 	})
 
-	err := n.Run()
-	ticker.Stop()
-	close(msgs)
-	if err != nil {
-		log.Fatal(err)
+	err := proto.Run(n)
+	close(done)
+	if ov != nil {
+		ov.Stop()
 	}
-}
-
-func getNeighbors(node string, nodes []string) []string {
-	nodeIndex, _ := strconv.Atoi(node[1:])
-	neighbors := make([]string, 0, 5)
-	parentIndex := (nodeIndex - 1) / 4
-	if parentIndex != nodeIndex {
-		neighbors = append(neighbors, nodes[parentIndex])
-	}
-	childIndex := nodeIndex * 4
-	for i := 1; i <= 4 && childIndex+i < len(nodes); i++ {
-		neighbors = append(neighbors, nodes[childIndex+i])
-	}
-	return neighbors
-}
-
-func castSlice[T any](slice []any) []T {
-	s := make([]T, 0, len(slice))
-	for _, v := range slice {
-		s = append(s, v.(T))
-	}
-	return s
+	members.Stop()
+	ae.Stop()
+	inflight.Wait()
+	ticker.Stop()
+	return err
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/batch"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/simtest"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+func TestPartition(t *testing.T) {
+	topo, err := topology.Parse("tree:4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	simtest.Run(t, sim.Config{Latency: 20 * time.Millisecond}, 10, func(n *maelstrom.Node, clk clock.Clock) error {
+		return run(n, clk, topo, batch.Options{}, false)
+	}, workload.Options{Workload: "broadcast", TimeLimit: 12 * time.Second, Rate: 50, Partition: true})
+}
@@ -2,19 +2,36 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
-	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
 )
 
+// local asks a node for its own share of the counter.
+type local struct{}
+
+type localOk struct {
+	Value int `json:"value"`
+}
+
+func (local) Type() string   { return "local" }
+func (localOk) Type() string { return "local_ok" }
+
 func main() {
-	n := maelstrom.NewNode()
+	if err := run(maelstrom.NewNode(), clock.Real()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node, clk clock.Clock) error {
 	kv := maelstrom.NewSeqKV(n)
+	rtts := rtt.New(clk, rtt.Options{})
 
 	readLocalValue := func() (int, error) {
-		return retry(3, func(ctx context.Context) (int, error) {
+		return retry(rtts, maelstrom.SeqKV, 3, func(ctx context.Context) (int, error) {
 			val, err := kv.ReadInt(ctx, n.ID())
 			if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
 				return 0, nil
@@ -23,26 +40,20 @@ func main() {
 		})
 	}
 
-	n.Handle("add", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		delta := int(body["delta"].(float64))
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Add) error {
 		val, err := readLocalValue()
 		if err != nil {
 			return err
 		}
-		if _, err := retry(3, func(ctx context.Context) (struct{}, error) {
-			return struct{}{}, kv.Write(ctx, n.ID(), delta+val)
+		if _, err := retry(rtts, maelstrom.SeqKV, 3, func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, kv.Write(ctx, n.ID(), req.Delta+val)
 		}); err != nil {
 			return err
 		}
-		return n.Reply(msg, map[string]any{"type": "add_ok"})
+		return proto.Reply(n, msg, proto.AddOk{})
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
 		var sum int
 		for _, node := range n.NodeIDs() {
 			var val int
@@ -50,17 +61,9 @@ func main() {
 			if node == n.ID() {
 				val, err = readLocalValue()
 			} else {
-				val, err = retry(3, func(ctx context.Context) (int, error) {
-					msg, err := n.SyncRPC(ctx, node, map[string]any{"type": "local"})
-					if err != nil {
-						return 0, err
-					}
-					var body map[string]any
-					if err := json.Unmarshal(msg.Body, &body); err != nil {
-						return 0, err
-					}
-					val = int(body["value"].(float64))
-					return val, nil
+				val, err = retry(rtts, node, 3, func(ctx context.Context) (int, error) {
+					resp, err := proto.RPC[localOk](ctx, n, node, local{})
+					return resp.Value, err
 				})
 			}
 			if err != nil {
@@ -68,33 +71,33 @@ func main() {
 			}
 			sum += val
 		}
-		return n.Reply(msg, map[string]any{"type": "read_ok", "value": sum})
+		return proto.Reply(n, msg, proto.ReadValueOk{Value: sum})
 	})
 
-	n.Handle("local", func(msg maelstrom.Message) error {
+	proto.Handle(n, func(msg maelstrom.Message, _ local) error {
 		val, err := readLocalValue()
 		if err != nil {
 			return err
 		}
 
-		return n.Reply(msg, map[string]any{"type": "local_ok", "value": val})
+		return proto.Reply(n, msg, localOk{Value: val})
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return proto.Run(n)
 }
 
-func retry[T any](attempts int, f func(ctx context.Context) (T, error)) (val T, err error) {
-	timeout := 500 * time.Millisecond
+// retry makes up to attempts requests to dest with f, each bounded by the
+// timeout derived from the round trips measured to dest.
+func retry[T any](rtts *rtt.Tracker, dest string, attempts int, f func(ctx context.Context) (T, error)) (val T, err error) {
 	for range attempts {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-		val, err = f(ctx)
+		err = rtts.Call(context.Background(), dest, func(ctx context.Context) error {
+			var err error
+			val, err = f(ctx)
+			return err
+		})
 		if err == nil {
 			break
 		}
-		timeout *= 2
 	}
 	return val, err
 }
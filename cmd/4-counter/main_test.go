@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/simtest"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+func TestPartition(t *testing.T) {
+	simtest.Run(t, sim.Config{}, 3, func(n *maelstrom.Node, clk clock.Clock) error {
+		return run(n, clk)
+	}, workload.Options{Workload: "g-counter", TimeLimit: 12 * time.Second, Rate: 50, Partition: true})
+}
@@ -2,63 +2,51 @@ package main
 
 import (
 	"cmp"
-	"encoding/json"
 	"log"
 	"slices"
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
 )
 
-type message [2]int
-
 func main() {
-	n := maelstrom.NewNode()
+	if err := run(maelstrom.NewNode()); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	messages := make(map[string][]message)
+func run(n *maelstrom.Node) error {
+	messages := make(map[string][]proto.LogEntry)
 	var messagesMu sync.RWMutex
 
 	committedOffsets := make(map[string]int)
 	var committedOffsetsMu sync.RWMutex
 
-	n.Handle("send", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		key := body["key"].(string)
-		m := int(body["msg"].(float64))
-
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Send) error {
 		messagesMu.Lock()
 		var offset int
-		msgs, ok := messages[key]
+		msgs, ok := messages[req.Key]
 		if ok {
-			offset = msgs[len(msgs)-1][0] + 1
+			offset = msgs[len(msgs)-1].Offset() + 1
 		}
-		msgs = append(msgs, message{offset, m})
-		messages[key] = msgs
+		msgs = append(msgs, proto.LogEntry{offset, req.Msg})
+		messages[req.Key] = msgs
 		messagesMu.Unlock()
-		return n.Reply(msg, map[string]any{"type": "send_ok", "offset": offset})
+		return proto.Reply(n, msg, proto.SendOk{Offset: offset})
 	})
 
-	n.Handle("poll", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		offsets := body["offsets"].(map[string]any)
-		msgs := make(map[string][]message)
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Poll) error {
+		msgs := make(map[string][]proto.LogEntry)
 
 		messagesMu.RLock()
-		for key, offset := range offsets {
+		for key, offset := range req.Offsets {
 			ms, ok := messages[key]
 			if !ok {
 				continue
 			}
-			i, ok := slices.BinarySearchFunc(ms, int(offset.(float64)), func(m message, off int) int {
-				return cmp.Compare(m[0], off)
+			i, ok := slices.BinarySearchFunc(ms, offset, func(m proto.LogEntry, off int) int {
+				return cmp.Compare(m.Offset(), off)
 			})
 			if !ok {
 				continue
@@ -66,20 +54,12 @@ func main() {
 			msgs[key] = ms[i:]
 		}
 		messagesMu.RUnlock()
-		return n.Reply(msg, map[string]any{"type": "poll_ok", "msgs": msgs})
+		return proto.Reply(n, msg, proto.PollOk{Msgs: msgs})
 	})
 
-	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		offsets := body["offsets"].(map[string]any)
-
+	proto.Handle(n, func(msg maelstrom.Message, req proto.CommitOffsets) error {
 		committedOffsetsMu.Lock()
-		for key, offset := range offsets {
-			offset := int(offset.(float64))
+		for key, offset := range req.Offsets {
 			committed := committedOffsets[key]
 			if offset > committed {
 				committed = offset
@@ -87,21 +67,14 @@ func main() {
 			committedOffsets[key] = committed
 		}
 		committedOffsetsMu.Unlock()
-		return n.Reply(msg, map[string]any{"type": "commit_offsets_ok"})
+		return proto.Reply(n, msg, proto.CommitOffsetsOk{})
 	})
 
-	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		keys := body["keys"].([]any)
-		offsets := make(map[string]int, len(keys))
+	proto.Handle(n, func(msg maelstrom.Message, req proto.ListCommittedOffsets) error {
+		offsets := make(map[string]int, len(req.Keys))
 
 		committedOffsetsMu.RLock()
-		for _, key := range keys {
-			key := key.(string)
+		for _, key := range req.Keys {
 			committed, ok := committedOffsets[key]
 			if !ok {
 				continue
@@ -110,10 +83,8 @@ func main() {
 		}
 		committedOffsetsMu.RUnlock()
 
-		return n.Reply(msg, map[string]any{"type": "list_committed_offsets_ok", "offsets": offsets})
+		return proto.Reply(n, msg, proto.ListCommittedOffsetsOk{Offsets: offsets})
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return proto.Run(n)
 }
@@ -2,17 +2,20 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
 )
 
-type message [2]int
-
 func main() {
-	n := maelstrom.NewNode()
+	if err := run(maelstrom.NewNode()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node) error {
 	seqkv := maelstrom.NewSeqKV(n)
 	linkv := maelstrom.NewLinKV(n)
 
@@ -33,22 +36,21 @@ func main() {
 
 	}
 
-	readMessages := func(key string, offset int) (messages []message) {
+	readMessages := func(key string, offset int) (messages []proto.LogEntry) {
 		for {
 			msg, err := seqkv.ReadInt(context.Background(), fmt.Sprintf("%s:%d", key, offset))
 			if err != nil {
 				break
 			}
-			messages = append(messages, message{offset, msg})
+			messages = append(messages, proto.LogEntry{offset, msg})
 			offset += 1
 		}
 		return messages
 	}
 
-	getCommittedOffsets := func(keys []any) map[string]int {
+	getCommittedOffsets := func(keys []string) map[string]int {
 		offsets := make(map[string]int)
 		for _, key := range keys {
-			key := key.(string)
 			offset, err := linkv.ReadInt(context.Background(), key)
 			if err == nil {
 				continue
@@ -58,60 +60,33 @@ func main() {
 		return offsets
 	}
 
-	n.Handle("send", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		key := body["key"].(string)
-		m := int(body["msg"].(float64))
-		offset := createMessage(key, m)
-		return n.Reply(msg, map[string]any{"type": "send_ok", "offset": offset})
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Send) error {
+		offset := createMessage(req.Key, req.Msg)
+		return proto.Reply(n, msg, proto.SendOk{Offset: offset})
 	})
 
-	n.Handle("poll", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		offsets := body["offsets"].(map[string]any)
-		msgs := make(map[string][]message)
-		for key, offset := range offsets {
-			ms := readMessages(key, int(offset.(float64)))
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Poll) error {
+		msgs := make(map[string][]proto.LogEntry)
+		for key, offset := range req.Offsets {
+			ms := readMessages(key, offset)
 			if ms != nil {
 				msgs[key] = ms
 			}
 		}
-		return n.Reply(msg, map[string]any{"type": "poll_ok", "msgs": msgs})
+		return proto.Reply(n, msg, proto.PollOk{Msgs: msgs})
 	})
 
-	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		offsets := body["offsets"].(map[string]any)
-		for key, offset := range offsets {
+	proto.Handle(n, func(msg maelstrom.Message, req proto.CommitOffsets) error {
+		for key, offset := range req.Offsets {
 			linkv.Write(context.Background(), fmt.Sprintf("%s:committed_offset", key), offset)
 		}
-		return n.Reply(msg, map[string]any{"type": "commit_offsets_ok"})
+		return proto.Reply(n, msg, proto.CommitOffsetsOk{})
 	})
 
-	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
-			return err
-		}
-
-		keys := body["keys"].([]any)
-		offsets := getCommittedOffsets(keys)
-		return n.Reply(msg, map[string]any{"type": "list_committed_offsets_ok", "offsets": offsets})
+	proto.Handle(n, func(msg maelstrom.Message, req proto.ListCommittedOffsets) error {
+		offsets := getCommittedOffsets(req.Keys)
+		return proto.Reply(n, msg, proto.ListCommittedOffsetsOk{Offsets: offsets})
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
-	}
+	return proto.Run(n)
 }
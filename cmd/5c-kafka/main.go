@@ -3,7 +3,6 @@ package main
 import (
 	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"slices"
@@ -11,38 +10,35 @@ import (
 	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+	"github.com/toxeeec/gossip-glomers/internal/swim"
 )
 
-type message [2]int
-
-type sendOkMsg struct {
-	Offset int `json:"offset"`
-}
-
-type pollOkMsg struct {
-	Msgs map[string][]message `json:"msgs"`
-}
-
-type listCommittedOffsetsOkMsg struct {
-	Offsets map[string]int `json:"offsets"`
+func main() {
+	if err := run(maelstrom.NewNode(), clock.Real()); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func main() {
-	n := maelstrom.NewNode()
+func run(n *maelstrom.Node, clk clock.Clock) error {
 	seqkv := maelstrom.NewSeqKV(n)
 	var seqkvMu sync.Mutex
 
+	members := swim.Start(n, clk, swim.Options{})
+	rtts := rtt.New(clk, rtt.Options{})
+
 	getOwner := func(key string) string {
 		keyId, _ := strconv.Atoi(key)
 		ownerId := keyId % len(n.NodeIDs())
 		return fmt.Sprintf("n%d", ownerId)
 	}
 
-	readMessages := func(offsets map[string]any) map[string][]message {
-		msgs := make(map[string][]message, len(offsets))
+	readMessages := func(offsets map[string]int) (map[string][]proto.LogEntry, error) {
+		msgs := make(map[string][]proto.LogEntry, len(offsets))
 		offsetsByOwner := make(map[string]map[string]int, len(n.NodeIDs()))
 		for key, offset := range offsets {
-			offset := int(offset.(float64))
 			owner := getOwner(key)
 			offsets, ok := offsetsByOwner[owner]
 			if ok {
@@ -56,56 +52,56 @@ func main() {
 		for owner, offsets := range offsetsByOwner {
 			if owner == n.ID() {
 				for key, offset := range offsets {
-					var ms []message
+					var ms []proto.LogEntry
 					seqkv.ReadInto(context.Background(), fmt.Sprintf("%s:messages", key), &ms)
-					i, ok := slices.BinarySearchFunc(ms, offset, func(msg message, off int) int {
-						return cmp.Compare(msg[0], off)
+					i, ok := slices.BinarySearchFunc(ms, offset, func(msg proto.LogEntry, off int) int {
+						return cmp.Compare(msg.Offset(), off)
 					})
 					if ok {
 						msgs[key] = ms[i:]
 					} else {
-						msgs[key] = []message{}
+						msgs[key] = []proto.LogEntry{}
 					}
 				}
 			} else {
-				b := map[string]any{"type": "poll", "offsets": offsets}
-				msg, _ := n.SyncRPC(context.Background(), owner, b)
-				var body pollOkMsg
-				json.Unmarshal(msg.Body, &body)
+				body, err := forward[proto.PollOk](rtts, n, members, owner, proto.Poll{Offsets: offsets})
+				if err != nil {
+					return nil, err
+				}
 				for key, ms := range body.Msgs {
 					msgs[key] = ms
 				}
 			}
 		}
-		return msgs
+		return msgs, nil
 	}
 
-	createMessage := func(key string, msg int) (offset int) {
+	createMessage := func(key string, msg int) (offset int, err error) {
 		owner := getOwner(key)
 
 		if owner == n.ID() {
 			seqkvMu.Lock()
-			msgs := readMessages(map[string]any{key: 0.0})[key]
+			all, _ := readMessages(map[string]int{key: 0})
+			msgs := all[key]
 			if len(msgs) > 0 {
-				offset = msgs[len(msgs)-1][0] + 1
+				offset = msgs[len(msgs)-1].Offset() + 1
 			}
-			msgs = append(msgs, message{offset, msg})
+			msgs = append(msgs, proto.LogEntry{offset, msg})
 			seqkv.Write(context.Background(), fmt.Sprintf("%s:messages", key), msgs)
 			seqkvMu.Unlock()
 		} else {
-			b := map[string]any{"type": "send", "key": key, "msg": msg}
-			msg, _ := n.SyncRPC(context.Background(), owner, b)
-			var body sendOkMsg
-			json.Unmarshal(msg.Body, &body)
+			body, err := forward[proto.SendOk](rtts, n, members, owner, proto.Send{Key: key, Msg: msg})
+			if err != nil {
+				return 0, err
+			}
 			offset = body.Offset
 		}
-		return offset
+		return offset, nil
 	}
 
-	commitOffsets := func(offsets map[string]any) {
+	commitOffsets := func(offsets map[string]int) error {
 		offsetsByOwner := make(map[string]map[string]int, len(n.NodeIDs()))
 		for key, offset := range offsets {
-			offset := int(offset.(float64))
 			owner := getOwner(key)
 			offsets, ok := offsetsByOwner[owner]
 			if ok {
@@ -123,23 +119,24 @@ func main() {
 				if err := seqkv.ReadInto(context.Background(), fmt.Sprintf("%s:committed_offsets", n.ID()), &committed); err != nil {
 					committed = make(map[string]int, len(offsets))
 				}
+				// Commits from different clients may arrive out of order,
+				// and committed offsets must never go back.
 				for key, offset := range offsets {
-					committed[key] = offset
+					committed[key] = max(committed[key], offset)
 				}
 				seqkv.Write(context.Background(), fmt.Sprintf("%s:committed_offsets", n.ID()), committed)
 				seqkvMu.Unlock()
-			} else {
-				body := map[string]any{"type": "commit_offsets", "offsets": offsets}
-				n.Send(owner, body)
+			} else if _, err := forward[proto.CommitOffsetsOk](rtts, n, members, owner, proto.CommitOffsets{Offsets: offsets}); err != nil {
+				return err
 			}
 		}
+		return nil
 	}
 
-	listCommittedOffsets := func(keys []any) map[string]int {
+	listCommittedOffsets := func(keys []string) (map[string]int, error) {
 		offsets := make(map[string]int, len(keys))
 		keysByOwner := make(map[string][]string, len(n.NodeIDs()))
 		for _, key := range keys {
-			key := key.(string)
 			owner := getOwner(key)
 			keys, ok := keysByOwner[owner]
 			if ok {
@@ -161,67 +158,62 @@ func main() {
 					}
 				}
 			} else {
-				b := map[string]any{"type": "list_committed_offsets", "keys": keys}
-				msg, _ := n.SyncRPC(context.Background(), owner, b)
-				var body listCommittedOffsetsOkMsg
-				json.Unmarshal(msg.Body, &body)
+				body, err := forward[proto.ListCommittedOffsetsOk](rtts, n, members, owner, proto.ListCommittedOffsets{Keys: keys})
+				if err != nil {
+					return nil, err
+				}
 				for key, offset := range body.Offsets {
 					offsets[key] = offset
 				}
 			}
 		}
-		return offsets
+		return offsets, nil
 	}
 
-	n.Handle("send", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Send) error {
+		offset, err := createMessage(req.Key, req.Msg)
+		if err != nil {
 			return err
 		}
-
-		key := body["key"].(string)
-		m := int(body["msg"].(float64))
-		offset := createMessage(key, m)
-		return n.Reply(msg, map[string]any{"type": "send_ok", "offset": offset})
+		return proto.Reply(n, msg, proto.SendOk{Offset: offset})
 	})
 
-	n.Handle("poll", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Poll) error {
+		msgs, err := readMessages(req.Offsets)
+		if err != nil {
 			return err
 		}
-
-		offsets := body["offsets"].(map[string]any)
-		msgs := readMessages(offsets)
-		return n.Reply(msg, map[string]any{"type": "poll_ok", "msgs": msgs})
+		return proto.Reply(n, msg, proto.PollOk{Msgs: msgs})
 	})
 
-	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+	proto.Handle(n, func(msg maelstrom.Message, req proto.CommitOffsets) error {
+		if err := commitOffsets(req.Offsets); err != nil {
 			return err
 		}
-
-		offsets := body["offsets"].(map[string]any)
-		commitOffsets(offsets)
-		if msg.Src[0] == 'n' {
-			return nil
-		}
-		return n.Reply(msg, map[string]any{"type": "commit_offsets_ok"})
+		return proto.Reply(n, msg, proto.CommitOffsetsOk{})
 	})
 
-	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
-		var body map[string]any
-		if err := json.Unmarshal(msg.Body, &body); err != nil {
+	proto.Handle(n, func(msg maelstrom.Message, req proto.ListCommittedOffsets) error {
+		offsets, err := listCommittedOffsets(req.Keys)
+		if err != nil {
 			return err
 		}
-
-		keys := body["keys"].([]any)
-		offsets := listCommittedOffsets(keys)
-		return n.Reply(msg, map[string]any{"type": "list_committed_offsets_ok", "offsets": offsets})
+		return proto.Reply(n, msg, proto.ListCommittedOffsetsOk{Offsets: offsets})
 	})
 
-	if err := n.Run(); err != nil {
-		log.Fatal(err)
+	err := proto.Run(n)
+	members.Stop()
+	return err
+}
+
+// forward sends req to the owner of a key and waits for its response. It
+// fails fast with TemporarilyUnavailable when owner is not reachable instead
+// of waiting on a partitioned node, and otherwise times out after the
+// timeout derived from the round trips measured to owner.
+func forward[Resp proto.Body](rtts *rtt.Tracker, n *maelstrom.Node, members *swim.Membership, owner string, req proto.Body) (Resp, error) {
+	if !members.Reachable(owner) {
+		var zero Resp
+		return zero, proto.Unavailable("%s is unreachable", owner)
 	}
+	return rtt.RPC[Resp](context.Background(), rtts, n, owner, req)
 }
@@ -1,30 +1,28 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"log"
 	"sync"
 	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/outbox"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+	"github.com/toxeeec/gossip-glomers/internal/swim"
 )
 
-type operation struct {
-	name  string
-	key   int
-	value *int
+// replicate carries the writes of a transaction to the other nodes.
+type replicate struct {
+	Txn       []proto.Op `json:"txn"`
+	Timestamp time.Time  `json:"timestamp"`
 }
 
-type transaction struct {
-	Ops       []operation `json:"txn"`
-	Timestamp time.Time   `json:"timestamp"`
-}
+type replicateOk struct{}
 
-type txnMsg struct {
-	dest string
-	body map[string]any
-}
+func (replicate) Type() string   { return "replicate" }
+func (replicateOk) Type() string { return "replicate_ok" }
 
 type entry struct {
 	value     int
@@ -32,100 +30,77 @@ type entry struct {
 }
 
 func main() {
-	n := maelstrom.NewNode()
+	if err := run(maelstrom.NewNode(), clock.Real()); err != nil {
+		log.Fatal(err)
+	}
+}
 
+func run(n *maelstrom.Node, clk clock.Clock) error {
 	kv := make(map[int]entry)
 	var kvMu sync.RWMutex
 
-	msgs := make(chan txnMsg)
-
-	go func() {
-		for msg := range msgs {
-			go func(msg txnMsg) {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
-				_, err := n.SyncRPC(ctx, msg.dest, msg.body)
-				if err != nil {
-					msgs <- msg
-				}
-			}(msg)
-		}
-	}()
+	members := swim.Start(n, clk, swim.Options{})
+	ob := outbox.New(n, clk, outbox.Options{Reachable: members.Reachable, RTT: rtt.New(clk, rtt.Options{})})
 
-	n.Handle("txn", func(msg maelstrom.Message) error {
-		var txn transaction
-		if err := json.Unmarshal(msg.Body, &txn); err != nil {
-			return err
+	commit := func(writes map[int]int, timestamp time.Time) {
+		for k, v := range writes {
+			kvMu.Lock()
+			e, ok := kv[k]
+			if !ok || timestamp.After(e.timestamp) {
+				kv[k] = entry{v, timestamp}
+			}
+			kvMu.Unlock()
 		}
+	}
 
-		if txn.Timestamp.IsZero() {
-			txn.Timestamp = time.Now()
-		}
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Txn) error {
+		timestamp := clk.Now()
 
 		writes := make(map[int]int)
-		for i, op := range txn.Ops {
-			if op.name == "r" {
-				val, ok := writes[op.key]
+		for i, op := range req.Txn {
+			if op.IsRead() {
+				val, ok := writes[op.Key]
 				if !ok {
 					var e entry
 					kvMu.RLock()
-					e, ok = kv[op.key]
+					e, ok = kv[op.Key]
 					kvMu.RUnlock()
 					val = e.value
 				}
 				if ok {
-					op.value = &val
-					txn.Ops[i] = op
+					op.Value = &val
+					req.Txn[i] = op
 				}
 			} else {
-				writes[op.key] = *op.value
+				writes[op.Key] = *op.Value
 			}
 		}
+		commit(writes, timestamp)
 
+		t := replicate{Timestamp: timestamp}
 		for k, v := range writes {
-			kvMu.Lock()
-			e, ok := kv[k]
-			if !ok || txn.Timestamp.After(e.timestamp) {
-				kv[k] = entry{v, txn.Timestamp}
-			}
-			kvMu.Unlock()
+			t.Txn = append(t.Txn, proto.Op{Name: "w", Key: k, Value: &v})
 		}
-
-		if msg.Src[0] != 'n' {
-			for _, node := range n.NodeIDs() {
-				if node == n.ID() {
-					continue
-				}
-				msgs <- txnMsg{node, map[string]any{"type": "txn", "txn": writes, "timestamp": txn.Timestamp}}
+		for _, node := range n.NodeIDs() {
+			if node == n.ID() {
+				continue
 			}
+			ob.Send(node, t)
 		}
-		return n.Reply(msg, map[string]any{"type": "txn_ok", "txn": txn.Ops})
+		return proto.Reply(n, msg, proto.TxnOk{Txn: req.Txn})
 	})
 
-	err := n.Run()
-	close(msgs)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func (o *operation) MarshalJSON() ([]byte, error) {
-	return json.Marshal([]any{o.name, o.key, o.value})
-}
-
-func (o *operation) UnmarshalJSON(data []byte) error {
-	var op []any
-	if err := json.Unmarshal(data, &op); err != nil {
-		return err
-	}
+	proto.Handle(n, func(msg maelstrom.Message, req replicate) error {
+		writes := make(map[int]int, len(req.Txn))
+		for _, op := range req.Txn {
+			writes[op.Key] = *op.Value
+		}
+		commit(writes, req.Timestamp)
+		return proto.Reply(n, msg, replicateOk{})
+	})
 
-	o.name = op[0].(string)
-	o.key = int(op[1].(float64))
-	val := op[2]
-	switch val := val.(type) {
-	case float64:
-		v := int(val)
-		o.value = &v
-	}
-	return nil
+	err := proto.Run(n)
+	members.Stop()
+	ob.Close()
+	return err
 }
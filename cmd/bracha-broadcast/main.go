@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/bracha"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+func main() {
+	if err := run(maelstrom.NewNode(), clock.Real()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node, clk clock.Clock) error {
+	// Every broadcast is a Bracha instance originating at the node that
+	// received it, and reads return the delivered values, so up to f
+	// faulty nodes out of 3f+1 cannot make correct nodes read different
+	// values for a broadcast.
+	b := bracha.Start(n, clk)
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		b.Broadcast(req.Message)
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
+		return proto.Reply(n, msg, proto.ReadMessagesOk{Messages: b.Log()})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Topology) error {
+		return proto.Reply(n, msg, proto.TopologyOk{})
+	})
+
+	err := proto.Run(n)
+	b.Stop()
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/simtest"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+var equivocating = workload.Options{Workload: "broadcast", TimeLimit: 10 * time.Second, Rate: 10, Byzantine: []string{"n3"}}
+
+func TestEquivocation(t *testing.T) {
+	simtest.Run(t, sim.Config{Latency: 10 * time.Millisecond}, 4, run, equivocating)
+}
+
+// TestEquivocationRelay checks that the equivocating node does break nodes
+// that forward whatever they receive, which read the negated values, so that
+// TestEquivocation would catch a broadcast that cannot tolerate it.
+func TestEquivocationRelay(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a workload for", equivocating.TimeLimit)
+	}
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+		t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	}
+	net := sim.New(sim.Config{Latency: 10 * time.Millisecond})
+	net.AddNodes(4, relay)
+	report, err := workload.Run(context.Background(), net, equivocating)
+	if closeErr := net.Close(); closeErr != nil {
+		t.Errorf("close: %v", closeErr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Result.Valid {
+		t.Fatal("got valid, want anomalies")
+	}
+}
+
+// relay forwards every new broadcast to every other node, as 3b does.
+func relay(n *maelstrom.Node) error {
+	var mu sync.Mutex
+	var msgs []int
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		mu.Lock()
+		fresh := !slices.Contains(msgs, req.Message)
+		if fresh {
+			msgs = append(msgs, req.Message)
+		}
+		mu.Unlock()
+		if fresh {
+			for _, node := range n.NodeIDs() {
+				if node != n.ID() {
+					proto.Notify(n, node, req)
+				}
+			}
+		}
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return proto.Reply(n, msg, proto.ReadMessagesOk{Messages: slices.Clone(msgs)})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Topology) error {
+		return proto.Reply(n, msg, proto.TopologyOk{})
+	})
+	return proto.Run(n)
+}
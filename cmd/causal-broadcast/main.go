@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/outbox"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+	"github.com/toxeeec/gossip-glomers/internal/vclock"
+)
+
+// causal carries a message from the node it was broadcast at, stamped with
+// that node's vector clock at the time.
+type causal struct {
+	Message int          `json:"message"`
+	Clock   vclock.Clock `json:"clock" required:"true"`
+}
+
+type causalOk struct{}
+
+func (causal) Type() string   { return "causal" }
+func (causalOk) Type() string { return "causal_ok" }
+
+// pending is a received message whose dependencies are not delivered yet.
+type pending struct {
+	from string
+	causal
+}
+
+func main() {
+	if err := run(maelstrom.NewNode(), clock.Real()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node, clk clock.Clock) error {
+	// delivered lists the messages in delivery order, which respects
+	// causality: a message broadcast after another was delivered at its
+	// origin is delivered after it everywhere. vc counts the delivered
+	// messages of every origin.
+	var delivered []int
+	var seen idset.Set
+	vc := vclock.New()
+	var buffer []pending
+	var mu sync.Mutex
+
+	ob := outbox.New(n, clk, outbox.Options{RTT: rtt.New(clk, rtt.Options{})})
+
+	// deliver counts a message from an origin. A message broadcast at
+	// more than one node is listed once. mu must be held.
+	deliver := func(from string, msg int) {
+		vc.Tick(from)
+		if seen.Add(msg) {
+			delivered = append(delivered, msg)
+		}
+	}
+
+	// drain delivers the buffered messages that have become deliverable
+	// and drops the ones that turned out to be duplicates. mu must be held.
+	drain := func() {
+		for progress := true; progress; {
+			progress = false
+			for i := 0; i < len(buffer); i++ {
+				p := buffer[i]
+				switch {
+				case p.Clock[p.from] <= vc[p.from]:
+				case vc.Deliverable(p.from, p.Clock):
+					deliver(p.from, p.Message)
+					progress = true
+				default:
+					continue
+				}
+				buffer = slices.Delete(buffer, i, i+1)
+				i--
+			}
+		}
+	}
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		mu.Lock()
+		if seen.Contains(req.Message) {
+			mu.Unlock()
+			return proto.Reply(n, msg, proto.BroadcastOk{})
+		}
+		deliver(n.ID(), req.Message)
+		c := causal{Message: req.Message, Clock: vc.Clone()}
+		mu.Unlock()
+
+		for _, node := range n.NodeIDs() {
+			if node != n.ID() {
+				ob.Send(node, c)
+			}
+		}
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, req causal) error {
+		mu.Lock()
+		if req.Clock[msg.Src] > vc[msg.Src] {
+			buffer = append(buffer, pending{msg.Src, req})
+			drain()
+		}
+		mu.Unlock()
+		return proto.Reply(n, msg, causalOk{})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
+		mu.Lock()
+		b := proto.ReadMessagesOk{Messages: append([]int{}, delivered...)}
+		mu.Unlock()
+		return proto.Reply(n, msg, b)
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Topology) error {
+		return proto.Reply(n, msg, proto.TopologyOk{})
+	})
+
+	err := proto.Run(n)
+	ob.Close()
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/crdt"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// state carries the sender's whole counter. Without -pn its decrements are
+// always empty.
+type state struct {
+	Counts crdt.PNCounter `json:"counts" required:"true"`
+}
+
+func (state) Type() string { return "counter_state" }
+
+func main() {
+	interval := flag.Duration("interval", 200*time.Millisecond, "time between two gossip rounds ($GLOMERS_INTERVAL)")
+	if v := os.Getenv("GLOMERS_INTERVAL"); v != "" {
+		if err := flag.Set("interval", v); err != nil {
+			log.Fatalf("GLOMERS_INTERVAL: %v", err)
+		}
+	}
+	pn := flag.Bool("pn", false, "accept negative deltas, for the pn-counter workload ($GLOMERS_PN)")
+	if v := os.Getenv("GLOMERS_PN"); v != "" {
+		if err := flag.Set("pn", v); err != nil {
+			log.Fatalf("GLOMERS_PN: %v", err)
+		}
+	}
+	flag.Parse()
+
+	if err := run(maelstrom.NewNode(), clock.Real(), *interval, *pn); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node, clk clock.Clock, interval time.Duration, pn bool) error {
+	// Every node adds to its own entries of the counter and sends the whole
+	// counter to the others every round, and reads are answered from the
+	// local copy, so they stay available during partitions and catch up
+	// once the gossip gets through again. Unless pn is set, the counter
+	// only grows.
+	counter := crdt.NewPNCounter()
+	var mu sync.Mutex
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Add) error {
+		if req.Delta < 0 && !pn {
+			return proto.Malformed("delta %d is negative", req.Delta)
+		}
+		mu.Lock()
+		counter.Add(n.ID(), req.Delta)
+		mu.Unlock()
+		return proto.Reply(n, msg, proto.AddOk{})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
+		mu.Lock()
+		b := proto.ReadValueOk{Value: counter.Value()}
+		mu.Unlock()
+		return proto.Reply(n, msg, b)
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, req state) error {
+		mu.Lock()
+		counter.Merge(req.Counts)
+		mu.Unlock()
+		return nil
+	})
+
+	ticker := clk.NewTicker(interval)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C():
+			}
+			if !proto.Initialized(n) {
+				continue
+			}
+
+			mu.Lock()
+			body := state{Counts: counter.Clone()}
+			mu.Unlock()
+			for _, node := range n.NodeIDs() {
+				if node != n.ID() {
+					proto.Notify(n, node, body)
+				}
+			}
+		}
+	}()
+
+	err := proto.Run(n)
+	close(done)
+	wg.Wait()
+	ticker.Stop()
+	return err
+}
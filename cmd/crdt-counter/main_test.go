@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/simtest"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+func TestPartition(t *testing.T) {
+	for _, tt := range []struct {
+		workload string
+		pn       bool
+	}{
+		{"g-counter", false},
+		{"pn-counter", true},
+	} {
+		t.Run(tt.workload, func(t *testing.T) {
+			simtest.Run(t, sim.Config{}, 3, func(n *maelstrom.Node, clk clock.Clock) error {
+				return run(n, clk, 200*time.Millisecond, tt.pn)
+			}, workload.Options{Workload: tt.workload, TimeLimit: 12 * time.Second, Rate: 50, Partition: true})
+		})
+	}
+}
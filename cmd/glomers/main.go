@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+type config struct {
+	Maelstrom  string      `json:"maelstrom"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	ID                string            `json:"id"`
+	Package           string            `json:"package"`
+	Workload          string            `json:"workload"`
+	NodeCount         int               `json:"node_count"`
+	TimeLimit         int               `json:"time_limit"`
+	Rate              float64           `json:"rate,omitempty"`
+	Concurrency       string            `json:"concurrency,omitempty"`
+	Latency           int               `json:"latency,omitempty"`
+	Nemesis           []string          `json:"nemesis,omitempty"`
+	Availability      string            `json:"availability,omitempty"`
+	ConsistencyModels string            `json:"consistency_models,omitempty"`
+	TotalOrder        bool              `json:"total_order,omitempty"`
+	Byzantine         []string          `json:"byzantine,omitempty"`
+	Env               map[string]string `json:"env,omitempty"`
+
+	// LocalOnly profiles need the simulator, such as the ones with faulty
+	// nodes. They are only run when named with -local.
+	LocalOnly bool `json:"local_only,omitempty"`
+
+	// ExpectFail profiles demonstrate a failure: they pass when the checker
+	// finds anomalies and fail when it does not.
+	ExpectFail bool `json:"expect_fail,omitempty"`
+}
+
+type result struct {
+	id         string
+	workload   string
+	expectFail bool
+	valid      bool
+	err        error
+	msgsPerOp  float64
+	duration   time.Duration
+	details    string
+}
+
+func main() {
+	log.SetFlags(0)
+	configPath := flag.String("config", "glomers.json", "challenge profiles")
+	maelstromPath := flag.String("maelstrom", "", "path to the maelstrom binary; overrides the config file")
+	local := flag.Bool("local", false, "run against the in-process simulator instead of Maelstrom")
+	seed := flag.Int64("seed", 0, "seed for the local simulator")
+	verbose := flag.Bool("v", false, "print anomalies and logs of failed challenges")
+	topo := flag.String("topology", "", "neighbour `strategy` for the broadcast challenges: "+topology.Usage)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [challenge_id... | all]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := readConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *maelstromPath != "" {
+		cfg.Maelstrom = *maelstromPath
+	}
+	if !*local && cfg.Maelstrom == "" {
+		log.Fatal("no maelstrom binary: pass -maelstrom, set \"maelstrom\" in the config file or use -local")
+	}
+
+	challenges, err := selectChallenges(cfg.Challenges, flag.Args(), *local)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *topo != "" {
+		if _, err := topology.Parse(*topo); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "glomers")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var results []result
+	for _, ch := range challenges {
+		fmt.Fprintf(os.Stderr, "running %s (%s)...\n", ch.ID, ch.Workload)
+		if *topo != "" {
+			env := make(map[string]string)
+			maps.Copy(env, ch.Env)
+			env[topology.EnvVar] = *topo
+			ch.Env = env
+		}
+		start := time.Now()
+		var res result
+		bin, err := build(ch, dir)
+		if err != nil {
+			res = result{err: err}
+		} else if *local {
+			res = runLocal(ch, bin, dir, *seed)
+		} else {
+			res = runMaelstrom(ch, cfg.Maelstrom, bin, dir)
+		}
+		res.id, res.workload, res.expectFail, res.duration = ch.ID, ch.Workload, ch.ExpectFail, time.Since(start)
+		results = append(results, res)
+	}
+
+	failed := printSummary(os.Stdout, results, *verbose)
+	if failed {
+		fmt.Printf("\nlogs are in %s\n", dir)
+		os.Exit(1)
+	}
+	os.RemoveAll(dir)
+}
+
+func readConfig(path string) (config, error) {
+	var cfg config
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// selectChallenges returns the challenges named by ids, or all but the local
+// only ones if there are none.
+func selectChallenges(all []challenge, ids []string, local bool) ([]challenge, error) {
+	var selected []challenge
+	if len(ids) == 0 || (len(ids) == 1 && ids[0] == "all") {
+		for _, ch := range all {
+			if !ch.LocalOnly {
+				selected = append(selected, ch)
+			}
+		}
+		return selected, nil
+	}
+	for _, id := range ids {
+		found := false
+		for _, ch := range all {
+			if ch.ID == id {
+				if ch.LocalOnly && !local {
+					return nil, fmt.Errorf("challenge %s only runs with -local", id)
+				}
+				selected = append(selected, ch)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown challenge id: %s", id)
+		}
+	}
+	return selected, nil
+}
+
+func build(ch challenge, dir string) (string, error) {
+	bin := filepath.Join(dir, "bin", ch.ID)
+	cmd := exec.Command("go", "build", "-o", bin, ch.Package)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("build %s: %w", ch.Package, err)
+	}
+	return bin, nil
+}
+
+func (ch challenge) environ() []string {
+	env := os.Environ()
+	for k, v := range ch.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+func (ch challenge) maelstromArgs(bin string) []string {
+	args := []string{
+		"test", "-w", ch.Workload, "--bin", bin,
+		"--node-count", strconv.Itoa(ch.NodeCount),
+		"--time-limit", strconv.Itoa(ch.TimeLimit),
+	}
+	if ch.Rate != 0 {
+		args = append(args, "--rate", strconv.FormatFloat(ch.Rate, 'f', -1, 64))
+	}
+	if ch.Concurrency != "" {
+		args = append(args, "--concurrency", ch.Concurrency)
+	}
+	if ch.Latency != 0 {
+		args = append(args, "--latency", strconv.Itoa(ch.Latency))
+	}
+	if len(ch.Nemesis) > 0 {
+		args = append(args, "--nemesis", strings.Join(ch.Nemesis, ","))
+	}
+	if ch.Availability != "" {
+		args = append(args, "--availability", ch.Availability)
+	}
+	if ch.ConsistencyModels != "" {
+		args = append(args, "--consistency-models", ch.ConsistencyModels)
+	}
+	return args
+}
+
+var msgsPerOpRe = regexp.MustCompile(`(?s):servers\s*\{[^}]*?:msgs-per-op\s+([0-9.]+)`)
+
+func runMaelstrom(ch challenge, maelstrom, bin, dir string) result {
+	if len(ch.Byzantine) > 0 {
+		return result{err: errors.New("byzantine nodes are only simulated with -local")}
+	}
+	logPath := filepath.Join(dir, ch.ID+".log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return result{err: err}
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(maelstrom, ch.maelstromArgs(bin)...)
+	cmd.Env = ch.environ()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	err = cmd.Run()
+
+	res := result{valid: err == nil, details: logPath}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		res.err = err
+		return res
+	}
+
+	// Maelstrom writes the results of the latest run to store/latest.
+	if buf, err := os.ReadFile(filepath.Join("store", "latest", "results.edn")); err == nil {
+		if m := msgsPerOpRe.FindSubmatch(buf); m != nil {
+			res.msgsPerOp, _ = strconv.ParseFloat(string(m[1]), 64)
+		}
+	}
+	return res
+}
+
+func runLocal(ch challenge, bin, dir string, seed int64) result {
+	logPath := filepath.Join(dir, ch.ID+".log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return result{err: err}
+	}
+	defer logFile.Close()
+
+	net := sim.New(sim.Config{Latency: time.Duration(ch.Latency) * time.Millisecond, Seed: seed})
+	workload.AddServices(net, seed)
+	for i := range ch.NodeCount {
+		cmd := exec.Command(bin)
+		cmd.Env = ch.environ()
+		cmd.Stderr = logFile
+		if err := net.AddCommand(fmt.Sprintf("n%d", i), cmd); err != nil {
+			net.Close()
+			return result{err: err}
+		}
+	}
+
+	opts := workload.Options{
+		Workload:         ch.Workload,
+		TimeLimit:        time.Duration(ch.TimeLimit) * time.Second,
+		Rate:             ch.Rate,
+		Concurrency:      ch.concurrency(),
+		ConsistencyModel: ch.ConsistencyModels,
+		TotalOrder:       ch.TotalOrder,
+		Byzantine:        ch.Byzantine,
+		Seed:             seed,
+	}
+	for _, n := range ch.Nemesis {
+		if n == "partition" {
+			opts.Partition = true
+		}
+	}
+
+	report, err := workload.Run(context.Background(), net, opts)
+	if closeErr := net.Close(); closeErr != nil {
+		fmt.Fprintf(logFile, "shutdown: %s\n", closeErr)
+	}
+	if err != nil {
+		return result{err: err, details: logPath}
+	}
+	return result{
+		valid:     report.Result.Valid,
+		msgsPerOp: report.MsgsPerOp(),
+		details:   report.Result.String() + "\nnode logs: " + logPath,
+	}
+}
+
+// concurrency parses the Maelstrom concurrency option, e.g. "10" or "2n".
+func (ch challenge) concurrency() int {
+	if ch.Concurrency == "" {
+		return 0
+	}
+	if n, ok := strings.CutSuffix(ch.Concurrency, "n"); ok {
+		k, _ := strconv.Atoi(n)
+		return k * ch.NodeCount
+	}
+	k, _ := strconv.Atoi(ch.Concurrency)
+	return k
+}
+
+func printSummary(w io.Writer, results []result, verbose bool) (failed bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tWORKLOAD\tRESULT\tMSGS/OP\tTIME")
+	for _, r := range results {
+		status := "pass"
+		switch {
+		case r.err != nil:
+			status = "error"
+			failed = true
+		case r.expectFail && !r.valid:
+			status = "fail (expected)"
+		case r.expectFail:
+			status = "pass (expected fail)"
+			failed = true
+		case !r.valid:
+			status = "fail"
+			failed = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%s\n", r.id, r.workload, status, r.msgsPerOp, r.duration.Round(100*time.Millisecond))
+	}
+	tw.Flush()
+
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			fmt.Fprintf(w, "\n%s: %s\n", r.id, r.err)
+		case r.expectFail && r.valid:
+			fmt.Fprintf(w, "\n%s: expected to fail, but the checker found no anomalies\n", r.id)
+		case !r.valid && verbose:
+			fmt.Fprintf(w, "\n%s: %s\n", r.id, r.details)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/antientropy"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/plumtree"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
+)
+
+func main() {
+	topo, err := topology.FromFlags("maelstrom")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(maelstrom.NewNode(), clock.Real(), topo); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node, clk clock.Clock, topo topology.Strategy) error {
+	var static []string
+	var topoMu sync.RWMutex
+	neighbors := func() []string {
+		topoMu.RLock()
+		defer topoMu.RUnlock()
+		return static
+	}
+
+	tree := plumtree.New(n, clk, plumtree.Options{})
+	ae := antientropy.Start(n, clk, antientropy.Options{
+		Interval: 500 * time.Millisecond,
+		Peers:    neighbors,
+		IDs:      tree.IDs,
+		Add:      tree.Add,
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		tree.Broadcast(req.Message)
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
+		return proto.Reply(n, msg, proto.ReadMessagesOk{Messages: tree.IDs()})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Topology) error {
+		topoMu.Lock()
+		static = topo(n.NodeIDs(), req.Topology)[n.ID()]
+		topoMu.Unlock()
+		tree.SetPeers(neighbors())
+		return proto.Reply(n, msg, proto.TopologyOk{})
+	})
+
+	err := proto.Run(n)
+	ae.Stop()
+	tree.Stop()
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/antientropy"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+func main() {
+	fanout := flag.Int("fanout", 2, "number of random peers to gossip with every round ($GLOMERS_FANOUT)")
+	interval := flag.Duration("interval", 200*time.Millisecond, "time between gossip rounds ($GLOMERS_INTERVAL)")
+	for name, env := range map[string]string{"fanout": "GLOMERS_FANOUT", "interval": "GLOMERS_INTERVAL"} {
+		if v := os.Getenv(env); v != "" {
+			if err := flag.Set(name, v); err != nil {
+				log.Fatalf("%s: %v", env, err)
+			}
+		}
+	}
+	flag.Parse()
+
+	if err := run(maelstrom.NewNode(), clock.Real(), *fanout, *interval); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node, clk clock.Clock, fanout int, interval time.Duration) error {
+	var ids idset.Set
+	var idsMu sync.RWMutex
+
+	var rng *rand.Rand
+
+	// Broadcasts are only recorded locally: every round the node exchanges
+	// digests with fanout peers picked at random, pulling the ids it is
+	// missing and pushing the ones they are missing.
+	ae := antientropy.Start(n, clk, antientropy.Options{
+		Interval: interval,
+		Fanout:   fanout,
+		Peers: func() []string {
+			if rng == nil {
+				h := fnv.New64a()
+				h.Write([]byte(n.ID()))
+				rng = rand.New(rand.NewSource(int64(h.Sum64())))
+			}
+			var peers []string
+			for _, node := range n.NodeIDs() {
+				if node != n.ID() {
+					peers = append(peers, node)
+				}
+			}
+			rng.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+			return peers
+		},
+		IDs: func() []int {
+			idsMu.RLock()
+			defer idsMu.RUnlock()
+			return ids.Slice()
+		},
+		Add: func(missing []int) {
+			idsMu.Lock()
+			for _, id := range missing {
+				ids.Add(id)
+			}
+			idsMu.Unlock()
+		},
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		idsMu.Lock()
+		ids.Add(req.Message)
+		idsMu.Unlock()
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
+		idsMu.RLock()
+		b := proto.ReadMessagesOk{Messages: ids.Slice()}
+		idsMu.RUnlock()
+		return proto.Reply(n, msg, b)
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Topology) error {
+		return proto.Reply(n, msg, proto.TopologyOk{})
+	})
+
+	err := proto.Run(n)
+	ae.Stop()
+	return err
+}
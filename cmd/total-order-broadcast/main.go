@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/sequencer"
+)
+
+func main() {
+	if err := run(maelstrom.NewNode(), clock.Real()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(n *maelstrom.Node, clk clock.Clock) error {
+	// Every broadcast is ordered by the sequencer, and reads return the
+	// committed prefix of its log, so all nodes read the same order.
+	seq := sequencer.Start(n, clk, sequencer.Options{})
+
+	proto.Handle(n, func(msg maelstrom.Message, req proto.Broadcast) error {
+		ctx, cancel := clk.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := seq.Submit(ctx, req.Message); err != nil {
+			return err
+		}
+		return proto.Reply(n, msg, proto.BroadcastOk{})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Read) error {
+		return proto.Reply(n, msg, proto.ReadMessagesOk{Messages: seq.Log()})
+	})
+
+	proto.Handle(n, func(msg maelstrom.Message, _ proto.Topology) error {
+		return proto.Reply(n, msg, proto.TopologyOk{})
+	})
+
+	err := proto.Run(n)
+	seq.Stop()
+	return err
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/simtest"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+func TestPartition(t *testing.T) {
+	simtest.Run(t, sim.Config{Latency: 20 * time.Millisecond}, 5, run,
+		workload.Options{Workload: "broadcast", TimeLimit: 12 * time.Second, Rate: 10, Partition: true, TotalOrder: true})
+}
@@ -2,4 +2,4 @@ module github.com/toxeeec/gossip-glomers
 
 go 1.22.3
 
-require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20240408130303-0186f398f965 // indirect
+require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20240408130303-0186f398f965
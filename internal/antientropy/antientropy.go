@@ -0,0 +1,178 @@
+// Package antientropy repairs differences between the id sets of
+// neighbouring nodes. Every interval a node exchanges digests of its set
+// with one neighbour, starting with wide ranges and narrowing down to the
+// ones that differ, until the neighbour answers with its ids in the
+// narrowest differing ranges; the node keeps the ids it was missing and
+// pushes back the ones the neighbour was missing.
+package antientropy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// syncReq starts an exchange with the digest of the sender's set.
+type syncReq struct {
+	Digest Digest `json:"digest" required:"true"`
+}
+
+func (r syncReq) Validate() error { return r.Digest.Validate() }
+
+// syncOk lists the starts of the ranges that differ. For digests of the
+// narrowest ranges it also carries the receiver's ids in them.
+type syncOk struct {
+	Ranges []int     `json:"ranges"`
+	IDs    idset.Set `json:"ids"`
+}
+
+// push carries the ids the receiver was missing.
+type push struct {
+	IDs idset.Set `json:"ids" required:"true"`
+}
+
+type pushOk struct{}
+
+func (syncReq) Type() string { return "sync" }
+func (syncOk) Type() string  { return "sync_ok" }
+func (push) Type() string    { return "sync_push" }
+func (pushOk) Type() string  { return "sync_push_ok" }
+
+// Options configure a Syncer. Peers, IDs and Add are only called once the
+// node is initialized.
+type Options struct {
+	// Interval is the time between two exchanges. Defaults to 1 second.
+	Interval time.Duration
+
+	// Peers returns the nodes to exchange with, which are tried in turn.
+	Peers func() []string
+
+	// Fanout is the number of peers exchanged with at once every interval.
+	// Defaults to 1.
+	Fanout int
+
+	// IDs returns a snapshot of the local set.
+	IDs func() []int
+
+	// Add is called with ids that were missing from the local set.
+	Add func(ids []int)
+}
+
+// Syncer runs the anti-entropy exchanges of a node.
+type Syncer struct {
+	n    *maelstrom.Node
+	clk  clock.Clock
+	opts Options
+
+	ticker clock.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Start registers the anti-entropy handlers on n and starts exchanging with
+// the peers. It must be called before n.Run.
+func Start(n *maelstrom.Node, clk clock.Clock, opts Options) *Syncer {
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Fanout == 0 {
+		opts.Fanout = 1
+	}
+	s := &Syncer{
+		n:      n,
+		clk:    clk,
+		opts:   opts,
+		ticker: clk.NewTicker(opts.Interval),
+		done:   make(chan struct{}),
+	}
+
+	proto.Handle(n, func(msg maelstrom.Message, req syncReq) error {
+		ids := opts.IDs()
+		diff := NewDigest(ids, req.Digest.Width, req.Digest.Within).Diff(req.Digest)
+		resp := syncOk{Ranges: diff}
+		if req.Digest.Width == minWidth {
+			resp.IDs = *idset.Of(inRanges(ids, diff, minWidth)...)
+		}
+		return proto.Reply(n, msg, resp)
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req push) error {
+		opts.Add(req.IDs.Slice())
+		return proto.Reply(n, msg, pushOk{})
+	})
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Stop stops the exchanges and waits for the current one to finish.
+func (s *Syncer) Stop() {
+	s.ticker.Stop()
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *Syncer) run() {
+	defer s.wg.Done()
+	next := 0
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ticker.C():
+		}
+		// Peers usually asks n for the node ids.
+		if !proto.Initialized(s.n) {
+			continue
+		}
+
+		peers := s.opts.Peers()
+		var wg sync.WaitGroup
+		for i := range min(s.opts.Fanout, len(peers)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.exchange(peers[(next+i)%len(peers)])
+			}()
+		}
+		wg.Wait()
+		next += s.opts.Fanout
+	}
+}
+
+// exchange reconciles the local set with peer's. Failures are ignored: the
+// next exchange with peer starts over.
+func (s *Syncer) exchange(peer string) {
+	ctx, cancel := s.clk.WithTimeout(context.Background(), s.opts.Interval)
+	defer cancel()
+
+	ids := s.opts.IDs()
+	var within []int
+	width := maxWidth
+	for {
+		resp, err := proto.RPC[syncOk](ctx, s.n, peer, syncReq{NewDigest(ids, width, within)})
+		if err != nil || len(resp.Ranges) == 0 {
+			return
+		}
+		if width > minWidth {
+			within = resp.Ranges
+			width /= fanout
+			continue
+		}
+
+		local := idset.Of(ids...)
+		if missing := resp.IDs.Diff(local); missing.Len() > 0 {
+			s.opts.Add(missing.Slice())
+		}
+		theirs := idset.Of(inRanges(ids, resp.Ranges, minWidth)...).Diff(&resp.IDs)
+		if theirs.Len() > 0 {
+			proto.RPC[pushOk](ctx, s.n, peer, push{*theirs})
+		}
+		return
+	}
+}
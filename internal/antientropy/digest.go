@@ -0,0 +1,151 @@
+package antientropy
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Digests are hierarchical: a range of one level is split into fanout
+// ranges of the next, down to ranges of minWidth consecutive ids.
+const (
+	minWidth = 32
+	fanout   = 32
+	maxWidth = minWidth * fanout * fanout
+)
+
+// Digest summarizes a set of ids as one Range for every run of Width
+// consecutive ids that holds at least one of them, sorted by Start. Ids that
+// are close together, such as ones handed out in sequence, share ranges, so
+// two sets that differ only in recent ids differ only in their last ranges.
+//
+// A digest narrower than maxWidth only covers the ids in the ranges of the
+// level above listed in Within, the ones found to differ in the previous
+// round, so its size grows with the differences rather than with the set.
+type Digest struct {
+	Width  int     `json:"width"`
+	Within []int   `json:"within,omitempty"`
+	Ranges []Range `json:"ranges"`
+}
+
+// Range summarizes the ids in [Start, Start+Width) by their number and the
+// XOR of their hashes. Hash is encoded as a string because Maelstrom bodies
+// are decoded as float64, which cannot hold every uint64.
+type Range struct {
+	Start int    `json:"start"`
+	Count int    `json:"count"`
+	Hash  uint64 `json:"hash,string"`
+}
+
+// NewDigest returns the digest of ids with ranges of the given width. If
+// width is below maxWidth, only the ids in the ranges of width*fanout
+// starting at within are summarized.
+func NewDigest(ids []int, width int, within []int) Digest {
+	if width < maxWidth {
+		ids = inRanges(ids, within, width*fanout)
+	}
+	ranges := make(map[int]*Range)
+	for _, id := range ids {
+		start := rangeStart(id, width)
+		r, ok := ranges[start]
+		if !ok {
+			r = &Range{Start: start}
+			ranges[start] = r
+		}
+		r.Count++
+		r.Hash ^= hash(id)
+	}
+
+	d := Digest{Width: width, Within: within, Ranges: make([]Range, 0, len(ranges))}
+	for _, r := range ranges {
+		d.Ranges = append(d.Ranges, *r)
+	}
+	slices.SortFunc(d.Ranges, func(a, b Range) int { return a.Start - b.Start })
+	return d
+}
+
+// Validate checks that d has the width of a level and that its ranges are
+// aligned and sorted.
+func (d Digest) Validate() error {
+	width := minWidth
+	for width < d.Width && width < maxWidth {
+		width *= fanout
+	}
+	if width != d.Width {
+		return fmt.Errorf("width %d is not a level of the digest", d.Width)
+	}
+	if width == maxWidth && len(d.Within) > 0 {
+		return fmt.Errorf("within is set on the widest level")
+	}
+	if err := validateStarts(d.Within, d.Width*fanout); err != nil {
+		return fmt.Errorf("within: %w", err)
+	}
+	starts := make([]int, len(d.Ranges))
+	for i, r := range d.Ranges {
+		starts[i] = r.Start
+	}
+	return validateStarts(starts, d.Width)
+}
+
+func validateStarts(starts []int, width int) error {
+	for i, start := range starts {
+		if start != rangeStart(start, width) {
+			return fmt.Errorf("range start %d is not a multiple of %d", start, width)
+		}
+		if i > 0 && start <= starts[i-1] {
+			return fmt.Errorf("ranges are not sorted")
+		}
+	}
+	return nil
+}
+
+// Diff returns the starts of the ranges in which d and other differ. Both
+// must have the same width and cover the same ranges of the level above.
+func (d Digest) Diff(other Digest) []int {
+	var diff []int
+	a, b := d.Ranges, other.Ranges
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0].Start < b[0].Start):
+			diff = append(diff, a[0].Start)
+			a = a[1:]
+		case len(a) == 0 || b[0].Start < a[0].Start:
+			diff = append(diff, b[0].Start)
+			b = b[1:]
+		default:
+			if a[0] != b[0] {
+				diff = append(diff, a[0].Start)
+			}
+			a, b = a[1:], b[1:]
+		}
+	}
+	return diff
+}
+
+// inRanges returns the ids that fall into one of the ranges of the given
+// width starting at starts.
+func inRanges(ids []int, starts []int, width int) []int {
+	want := make(map[int]bool, len(starts))
+	for _, s := range starts {
+		want[s] = true
+	}
+	var selected []int
+	for _, id := range ids {
+		if want[rangeStart(id, width)] {
+			selected = append(selected, id)
+		}
+	}
+	return selected
+}
+
+func rangeStart(id, width int) int {
+	return id - ((id%width)+width)%width
+}
+
+// hash mixes id with the splitmix64 finalizer, so that the XOR of a range
+// is unlikely to match for different sets.
+func hash(id int) uint64 {
+	x := uint64(id) + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,51 @@
+package antientropy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDigestDiffNarrowsDown(t *testing.T) {
+	var a, b []int
+	for id := range 100_000 {
+		a = append(a, id)
+		if id != 70_000 {
+			b = append(b, id)
+		}
+	}
+
+	var within []int
+	for width := maxWidth; ; width /= fanout {
+		da, db := NewDigest(a, width, within), NewDigest(b, width, within)
+		if len(within) > 0 && len(da.Ranges) > fanout {
+			t.Fatalf("width %d: %d ranges, want at most %d", width, len(da.Ranges), fanout)
+		}
+		within = da.Diff(db)
+		if len(within) != 1 || within[0] != rangeStart(70_000, width) {
+			t.Fatalf("width %d: got diff %v, want the range of 70000", width, within)
+		}
+		if width == minWidth {
+			break
+		}
+	}
+	if got := inRanges(a, within, minWidth); !slices.Contains(got, 70_000) || len(got) != minWidth {
+		t.Fatalf("got %v, want the 32 ids around 70000", got)
+	}
+}
+
+func TestDigestValidate(t *testing.T) {
+	for _, d := range []Digest{
+		{Width: 33},
+		{Width: 1 << 62},
+		{Width: maxWidth, Within: []int{0}},
+		{Width: minWidth, Within: []int{1}},
+		{Width: minWidth, Ranges: []Range{{Start: 64}, {Start: 32}}},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("%+v: got nil, want an error", d)
+		}
+	}
+	if err := NewDigest([]int{-5, 3, 40}, minWidth, []int{-1024, 0}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package batch decides when to flush batched messages. A batch is flushed
+// once it holds Size messages or once it has waited for the current
+// interval, whichever comes first. The interval is the time the observed
+// inbound rate takes to fill a batch, capped at a fraction of the smoothed
+// round trip time so that batching adds little latency compared to the
+// links themselves.
+package batch
+
+import (
+	"sync"
+	"time"
+
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+)
+
+// Options configure a Policy. Zero values select the defaults.
+type Options struct {
+	// Size is the number of messages that flushes a batch without waiting.
+	// Defaults to 32.
+	Size int
+
+	// MinInterval and MaxInterval bound the interval. They default to 20
+	// and 500 milliseconds.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+
+	// RTTFraction caps the interval at this fraction of the smoothed round
+	// trip time. Defaults to 0.5.
+	RTTFraction float64
+}
+
+// Policy tracks the inbound rate and the round trip time. It is safe for
+// concurrent use.
+type Policy struct {
+	clk  clock.Clock
+	opts Options
+
+	mu    sync.Mutex
+	count int
+	since time.Time
+	rate  float64
+	srtt  time.Duration
+}
+
+// rateWeight and rttWeight are the weights of a new sample in the moving
+// averages of the rate and the round trip time.
+const (
+	rateWeight = 0.25
+	rttWeight  = 0.125
+)
+
+// New returns a policy that has observed nothing yet, so its interval is
+// MaxInterval.
+func New(clk clock.Clock, opts Options) *Policy {
+	if opts.Size == 0 {
+		opts.Size = 32
+	}
+	if opts.MinInterval == 0 {
+		opts.MinInterval = 20 * time.Millisecond
+	}
+	if opts.MaxInterval == 0 {
+		opts.MaxInterval = 500 * time.Millisecond
+	}
+	if opts.RTTFraction == 0 {
+		opts.RTTFraction = 0.5
+	}
+	return &Policy{clk: clk, opts: opts, since: clk.Now()}
+}
+
+// Add counts n inbound messages.
+func (p *Policy) Add(n int) {
+	p.mu.Lock()
+	p.count += n
+	p.mu.Unlock()
+}
+
+// ObserveRTT records a round trip time.
+func (p *Policy) ObserveRTT(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.srtt == 0 {
+		p.srtt = d
+		return
+	}
+	p.srtt += time.Duration(rttWeight * float64(d-p.srtt))
+}
+
+// Full reports whether a batch of n messages should be flushed now.
+func (p *Policy) Full(n int) bool {
+	return n >= p.opts.Size
+}
+
+// Interval folds the messages added since the last call into the rate and
+// returns how long the next batch may wait.
+func (p *Policy) Interval() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clk.Now()
+	if elapsed := now.Sub(p.since).Seconds(); elapsed > 0 {
+		p.rate += rateWeight * (float64(p.count)/elapsed - p.rate)
+		p.count, p.since = 0, now
+	}
+
+	interval := p.opts.MaxInterval
+	if p.rate > 0 {
+		interval = min(interval, time.Duration(float64(p.opts.Size)/p.rate*float64(time.Second)))
+	}
+	if p.srtt > 0 {
+		interval = min(interval, time.Duration(p.opts.RTTFraction*float64(p.srtt)))
+	}
+	return max(interval, p.opts.MinInterval)
+}
@@ -0,0 +1,243 @@
+// Package bracha implements Bracha's reliable broadcast, which tolerates f
+// Byzantine nodes out of n >= 3f+1. Every broadcast is an instance named by
+// its origin and a sequence number, and goes through three phases:
+//
+//   - send: the origin sends its value to every node.
+//   - echo: every node echoes the first value the origin sent it to every
+//     node.
+//   - ready: a node that got echoes for a value from more than (n+f)/2
+//     nodes, or readies for it from f+1 nodes, announces that it is ready
+//     to deliver it, and a node delivers a value once 2f+1 nodes are ready.
+//
+// Two quorums of echoes share a correct node, which echoes one value only, so
+// correct nodes never get ready for different values of an instance. Once
+// one correct node delivers a value, 2f+1 nodes are ready for it, so every
+// correct node gets f+1 readies, becomes ready itself and delivers it too.
+package bracha
+
+import (
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/outbox"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+)
+
+// payload names an instance and carries a value for it.
+type payload struct {
+	Origin  string `json:"origin" required:"true"`
+	Seq     int    `json:"seq"`
+	Message int    `json:"message"`
+}
+
+type (
+	send    payload
+	echo    payload
+	ready   payload
+	sendOk  struct{}
+	echoOk  struct{}
+	readyOk struct{}
+)
+
+func (send) Type() string    { return "bracha_send" }
+func (echo) Type() string    { return "bracha_echo" }
+func (ready) Type() string   { return "bracha_ready" }
+func (sendOk) Type() string  { return "bracha_send_ok" }
+func (echoOk) Type() string  { return "bracha_echo_ok" }
+func (readyOk) Type() string { return "bracha_ready_ok" }
+
+type instance struct {
+	origin string
+	seq    int
+}
+
+// state is what a node knows about an instance. echoes and readies hold the
+// value every node echoed or is ready for, so that each node is counted once.
+type state struct {
+	echoed    bool
+	readied   bool
+	delivered bool
+	echoes    map[string]int
+	readies   map[string]int
+}
+
+type phase int
+
+const (
+	sendPhase phase = iota
+	echoPhase
+	readyPhase
+)
+
+// message is a phase message from a node, possibly the node itself.
+type message struct {
+	from  string
+	phase phase
+	payload
+}
+
+func (m message) body() proto.Body {
+	switch m.phase {
+	case sendPhase:
+		return send(m.payload)
+	case echoPhase:
+		return echo(m.payload)
+	}
+	return ready(m.payload)
+}
+
+// Broadcast is a node's part in every instance.
+type Broadcast struct {
+	n  *maelstrom.Node
+	ob *outbox.Outbox
+
+	mu        sync.Mutex
+	seq       int
+	instances map[instance]*state
+
+	// delivered lists the delivered values in delivery order. A value
+	// delivered by more than one instance is listed once.
+	delivered []int
+	seen      idset.Set
+}
+
+// Start registers the broadcast handlers on n. It must be called before
+// n.Run.
+func Start(n *maelstrom.Node, clk clock.Clock) *Broadcast {
+	b := &Broadcast{
+		n:         n,
+		ob:        outbox.New(n, clk, outbox.Options{RTT: rtt.New(clk, rtt.Options{})}),
+		instances: make(map[instance]*state),
+	}
+
+	proto.Handle(n, func(msg maelstrom.Message, req send) error {
+		b.receive(message{msg.Src, sendPhase, payload(req)})
+		return proto.Reply(n, msg, sendOk{})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req echo) error {
+		b.receive(message{msg.Src, echoPhase, payload(req)})
+		return proto.Reply(n, msg, echoOk{})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req ready) error {
+		b.receive(message{msg.Src, readyPhase, payload(req)})
+		return proto.Reply(n, msg, readyOk{})
+	})
+	return b
+}
+
+// Stop stops retrying the messages that were not acknowledged yet.
+func (b *Broadcast) Stop() {
+	b.ob.Close()
+}
+
+// Broadcast starts a new instance for msg with the node as its origin.
+func (b *Broadcast) Broadcast(msg int) {
+	b.mu.Lock()
+	b.seq++
+	p := payload{Origin: b.n.ID(), Seq: b.seq, Message: msg}
+	b.mu.Unlock()
+	b.receive(message{b.n.ID(), sendPhase, p})
+}
+
+// Log returns the delivered values in delivery order.
+func (b *Broadcast) Log() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int{}, b.delivered...)
+}
+
+// receive handles m, along with every message it makes the node send to
+// itself, and sends the others their copies. m is sent to the others too if
+// it is from the node itself.
+func (b *Broadcast) receive(m message) {
+	var out []message
+	if m.from == b.n.ID() {
+		out = append(out, m)
+	}
+	b.mu.Lock()
+	queue := []message{m}
+	for len(queue) > 0 {
+		next := b.step(queue[0])
+		queue = append(queue[1:], next...)
+		out = append(out, next...)
+	}
+	b.mu.Unlock()
+
+	for _, m := range out {
+		for _, node := range b.n.NodeIDs() {
+			if node != b.n.ID() {
+				b.ob.Send(node, m.body())
+			}
+		}
+	}
+}
+
+// step advances the instance of m and returns the messages the node sends
+// to every node in response. b.mu must be held.
+func (b *Broadcast) step(m message) []message {
+	nodes := len(b.n.NodeIDs())
+	f := (nodes - 1) / 3
+	st := b.instance(m.Origin, m.Seq)
+	reply := func(ph phase) []message {
+		return []message{{b.n.ID(), ph, m.payload}}
+	}
+
+	switch m.phase {
+	case sendPhase:
+		// Only the origin may send, and only its first value is echoed.
+		if m.from == m.Origin && !st.echoed {
+			st.echoed = true
+			return reply(echoPhase)
+		}
+	case echoPhase:
+		if _, ok := st.echoes[m.from]; ok {
+			return nil
+		}
+		st.echoes[m.from] = m.Message
+		if !st.readied && count(st.echoes, m.Message) > (nodes+f)/2 {
+			st.readied = true
+			return reply(readyPhase)
+		}
+	case readyPhase:
+		if _, ok := st.readies[m.from]; ok {
+			return nil
+		}
+		st.readies[m.from] = m.Message
+		readies := count(st.readies, m.Message)
+		if !st.delivered && readies >= 2*f+1 {
+			st.delivered = true
+			if b.seen.Add(m.Message) {
+				b.delivered = append(b.delivered, m.Message)
+			}
+		}
+		if !st.readied && readies >= f+1 {
+			st.readied = true
+			return reply(readyPhase)
+		}
+	}
+	return nil
+}
+
+func (b *Broadcast) instance(origin string, seq int) *state {
+	key := instance{origin, seq}
+	st, ok := b.instances[key]
+	if !ok {
+		st = &state{echoes: make(map[string]int), readies: make(map[string]int)}
+		b.instances[key] = st
+	}
+	return st
+}
+
+// count returns the number of nodes that voted for v.
+func count(votes map[string]int, v int) int {
+	c := 0
+	for _, vote := range votes {
+		if vote == v {
+			c++
+		}
+	}
+	return c
+}
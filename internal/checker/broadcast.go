@@ -0,0 +1,146 @@
+package checker
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// BroadcastValue is the value of a broadcast workload operation: the message
+// of a broadcast, or the messages returned by a read.
+type BroadcastValue struct {
+	Message  int   `json:"message,omitempty"`
+	Messages []int `json:"messages,omitempty"`
+}
+
+// CheckBroadcast checks a broadcast workload history for:
+//   - unexpected: a read returning a message that was never broadcast;
+//   - lost: an acknowledged message missing from a final read;
+//   - no-final-read: the history has no final read.
+func CheckBroadcast(h History[BroadcastValue]) Result {
+	res := newResult()
+	calls := h.calls()
+
+	attempted := make(map[int]bool)
+	var acked []call[BroadcastValue]
+	var reads []call[BroadcastValue]
+	var lastBroadcast time.Duration
+	for _, c := range calls {
+		switch c.invoke.F {
+		case "broadcast":
+			if c.complete.Type == Fail {
+				continue
+			}
+			attempted[c.invoke.Value.Message] = true
+			if c.ok() {
+				acked = append(acked, c)
+				lastBroadcast = max(lastBroadcast, c.complete.Time)
+			} else {
+				lastBroadcast = max(lastBroadcast, c.invoke.Time)
+			}
+		case "read", FinalRead:
+			if c.ok() {
+				reads = append(reads, c)
+			}
+		}
+	}
+
+	final := 0
+	for _, r := range reads {
+		read := make(map[int]bool, len(r.complete.Value.Messages))
+		for _, m := range r.complete.Value.Messages {
+			read[m] = true
+			if !attempted[m] {
+				res.add("unexpected", fmt.Sprintf("read returned %d, which was never broadcast", m), r.complete)
+			}
+		}
+
+		if r.invoke.F != FinalRead || r.invoke.Time <= lastBroadcast {
+			continue
+		}
+		final++
+		var lost []int
+		for _, b := range acked {
+			if !read[b.invoke.Value.Message] {
+				lost = append(lost, b.invoke.Value.Message)
+			}
+		}
+		if len(lost) > 0 {
+			res.add("lost", fmt.Sprintf("final read by %s is missing %d acknowledged messages: %v",
+				r.invoke.Process, len(lost), lost), r.complete)
+		}
+	}
+	if final == 0 && len(acked) > 0 {
+		res.add("no-final-read", "no final read was invoked after every broadcast completed")
+	}
+	return res
+}
+
+// CheckTotalOrder checks a broadcast workload history like CheckBroadcast, and
+// also for:
+//   - order: a read that is not a prefix of the longest read, so two nodes,
+//     or one node at two times, listed the messages in different orders.
+//
+// Every pair of reads are prefixes of one another exactly when every read is
+// a prefix of the longest one, so only that comparison is made.
+func CheckTotalOrder(h History[BroadcastValue]) Result {
+	res := CheckBroadcast(h)
+
+	var reads []call[BroadcastValue]
+	for _, c := range h.calls() {
+		if (c.invoke.F == "read" || c.invoke.F == FinalRead) && c.ok() {
+			reads = append(reads, c)
+		}
+	}
+	if len(reads) == 0 {
+		return res
+	}
+	longest := reads[0]
+	for _, r := range reads[1:] {
+		if len(r.complete.Value.Messages) > len(longest.complete.Value.Messages) {
+			longest = r
+		}
+	}
+
+	want := longest.complete.Value.Messages
+	for _, r := range reads {
+		for i, m := range r.complete.Value.Messages {
+			if m != want[i] {
+				res.add("order", fmt.Sprintf("read by %s lists %d at position %d, where the read by %s lists %d",
+					r.invoke.Process, m, i, longest.invoke.Process, want[i]), r.complete, longest.complete)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// CheckAgreement checks a broadcast workload history like CheckBroadcast, and
+// also for:
+//   - disagreement: two final reads that list different messages, so the
+//     nodes did not deliver the same broadcasts.
+//
+// It suits histories with faulty nodes, whose broadcasts are indeterminate
+// but must still be delivered by every node or by none.
+func CheckAgreement(h History[BroadcastValue]) Result {
+	res := CheckBroadcast(h)
+
+	var first *call[BroadcastValue]
+	var want []int
+	for _, c := range h.calls() {
+		if c.invoke.F != FinalRead || !c.ok() {
+			continue
+		}
+		got := slices.Clone(c.complete.Value.Messages)
+		slices.Sort(got)
+		if first == nil {
+			first, want = &c, got
+			continue
+		}
+		if !slices.Equal(got, want) {
+			res.add("disagreement", fmt.Sprintf("final read by %s lists %d messages, where the final read by %s lists %d",
+				c.invoke.Process, len(got), first.invoke.Process, len(want)), c.complete, first.complete)
+		}
+	}
+	return res
+}
@@ -0,0 +1,64 @@
+package checker
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// broadcastHistory builds a history in which process a broadcasts every
+// message, one after another, and then every read and every final read runs
+// by its own process, in that order. Every read is given as the messages it
+// returned.
+func broadcastHistory(messages []int, reads, finals [][]int) History[BroadcastValue] {
+	var h History[BroadcastValue]
+	add := func(process, f string, invoke, complete BroadcastValue) {
+		i := len(h)
+		h = append(h,
+			Op[BroadcastValue]{Process: process, Type: Invoke, F: f, Value: invoke, Time: time.Duration(i), Index: i},
+			Op[BroadcastValue]{Process: process, Type: Ok, F: f, Value: complete, Time: time.Duration(i + 1), Index: i + 1})
+	}
+	for _, m := range messages {
+		add("a", "broadcast", BroadcastValue{Message: m}, BroadcastValue{Message: m})
+	}
+	for i, read := range slices.Concat(reads, finals) {
+		f := "read"
+		if i >= len(reads) {
+			f = FinalRead
+		}
+		add(string(rune('b'+i)), f, BroadcastValue{}, BroadcastValue{Messages: read})
+	}
+	return h
+}
+
+func TestCheckTotalOrderPrefixes(t *testing.T) {
+	h := broadcastHistory([]int{1, 2, 3}, [][]int{{}, {2}, {2, 1}, {2, 1, 3}}, [][]int{{2, 1, 3}, {2, 1, 3}})
+
+	if res := CheckTotalOrder(h); !res.Valid {
+		t.Fatalf("got %v, want valid", res)
+	}
+}
+
+func TestCheckTotalOrderDivergingReads(t *testing.T) {
+	// Both final reads hold every message, which CheckBroadcast accepts, but
+	// the nodes delivered 1 and 2 in different orders.
+	h := broadcastHistory([]int{1, 2, 3}, nil, [][]int{{1, 2, 3}, {2, 1, 3}})
+
+	if res := CheckBroadcast(h); !res.Valid {
+		t.Fatalf("CheckBroadcast: got %v, want valid", res)
+	}
+	res := CheckTotalOrder(h)
+	if got := kinds(res); len(got) != 1 || got[0] != "order" {
+		t.Fatalf("got %v, want one order anomaly", res)
+	}
+}
+
+func TestCheckTotalOrderDivergingShorterRead(t *testing.T) {
+	// The shorter read is not a prefix of the longest one.
+	h := broadcastHistory([]int{1, 2, 3}, [][]int{{1, 3}}, [][]int{{1, 2, 3}, {1, 2, 3}})
+
+	res := CheckTotalOrder(h)
+	if got := kinds(res); len(got) != 1 || got[0] != "order" {
+		t.Fatalf("got %v, want one order anomaly", res)
+	}
+}
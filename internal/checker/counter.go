@@ -0,0 +1,93 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CheckCounter checks a counter workload history, where add operations carry
+// their delta and read operations complete with the value read. Deltas may be
+// negative, so the same check serves both g-counter and pn-counter. It reports:
+//   - impossible-read: a read outside the range that the adds invoked before
+//     it completed could produce;
+//   - final-read: a final read that does not account for exactly the
+//     acknowledged adds, plus any subset of the adds with unknown outcome;
+//   - no-final-read: the history has no final read.
+func CheckCounter(h History[int]) Result {
+	res := newResult()
+	calls := h.calls()
+
+	var adds, reads []call[int]
+	for _, c := range calls {
+		switch c.invoke.F {
+		case "add":
+			adds = append(adds, c)
+		case "read", FinalRead:
+			if c.ok() {
+				reads = append(reads, c)
+			}
+		}
+	}
+
+	var lower, upper int
+	var lastAdd time.Duration
+	for _, c := range adds {
+		d := c.invoke.Value
+		switch c.complete.Type {
+		case Ok:
+			lower += d
+			upper += d
+			lastAdd = max(lastAdd, c.complete.Time)
+		case Info:
+			lower += min(d, 0)
+			upper += max(d, 0)
+			lastAdd = max(lastAdd, c.invoke.Time)
+		}
+	}
+
+	checkCounterReads(&res, adds, reads)
+
+	final := 0
+	for _, r := range reads {
+		if r.invoke.F != FinalRead || r.invoke.Time <= lastAdd {
+			continue
+		}
+		final++
+		if v := r.complete.Value; v < lower || v > upper {
+			res.add("final-read",
+				fmt.Sprintf("final read %d is outside the expected range [%d, %d]", v, lower, upper), r.complete)
+		}
+	}
+	if final == 0 && len(adds) > 0 {
+		res.add("no-final-read", "no final read was invoked after every add completed")
+	}
+	return res
+}
+
+// checkCounterReads bounds every read by the adds that may have happened
+// before it: any add invoked before the read completed, whatever its outcome.
+func checkCounterReads(res *Result, adds, reads []call[int]) {
+	possible := make([]call[int], 0, len(adds))
+	for _, c := range adds {
+		if c.complete.Type != Fail {
+			possible = append(possible, c)
+		}
+	}
+	sort.Slice(possible, func(i, j int) bool { return possible[i].invoke.Time < possible[j].invoke.Time })
+
+	pos := make([]int, len(possible)+1)
+	neg := make([]int, len(possible)+1)
+	for i, c := range possible {
+		pos[i+1] = pos[i] + max(c.invoke.Value, 0)
+		neg[i+1] = neg[i] + min(c.invoke.Value, 0)
+	}
+
+	for _, r := range reads {
+		i := sort.Search(len(possible), func(i int) bool { return possible[i].invoke.Time >= r.complete.Time })
+		if v := r.complete.Value; v < neg[i] || v > pos[i] {
+			res.add("impossible-read",
+				fmt.Sprintf("read %d is outside the possible range [%d, %d]", v, neg[i], pos[i]), r.complete)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package checker
+
+import (
+	"slices"
+	"testing"
+)
+
+// add and read return the invocation and completion of an operation that
+// no other operation overlaps.
+func add(process string, delta int, typ OpType) []Op[int] {
+	return []Op[int]{invoke(process, "add", delta), complete(process, typ, "add", delta)}
+}
+
+func read(process, f string, value int) []Op[int] {
+	return []Op[int]{invoke(process, f, 0), complete(process, Ok, f, value)}
+}
+
+func TestCheckCounter(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ops  [][]Op[int]
+		want string
+	}{
+		{"valid", [][]Op[int]{add("a", 1, Ok), read("b", "read", 1), add("a", 2, Ok), read("b", FinalRead, 3)}, ""},
+		{"stale read", [][]Op[int]{add("a", 1, Ok), read("b", "read", 0), add("a", 2, Ok), read("b", FinalRead, 3)}, ""},
+		{"unknown add applied", [][]Op[int]{add("a", 1, Ok), add("a", 2, Info), read("b", FinalRead, 3)}, ""},
+		{"unknown add not applied", [][]Op[int]{add("a", 1, Ok), add("a", 2, Info), read("b", FinalRead, 1)}, ""},
+		{"failed add", [][]Op[int]{add("a", 1, Ok), add("a", 2, Fail), read("b", FinalRead, 1)}, ""},
+		{"negative deltas", [][]Op[int]{add("a", 3, Ok), add("a", -5, Ok), read("b", "read", -2), read("b", FinalRead, -2)}, ""},
+		{"read above the adds", [][]Op[int]{add("a", 1, Ok), read("b", "read", 5), read("b", FinalRead, 1)}, "impossible-read"},
+		{"read below the adds", [][]Op[int]{add("a", -1, Ok), read("b", "read", -2), read("b", FinalRead, -1)}, "impossible-read"},
+		{"read before the add", [][]Op[int]{read("b", "read", 1), add("a", 1, Ok), read("b", FinalRead, 1)}, "impossible-read"},
+		{"lost add", [][]Op[int]{add("a", 1, Ok), add("a", 2, Ok), read("b", FinalRead, 1)}, "final-read"},
+		{"failed add applied", [][]Op[int]{add("a", 1, Ok), add("a", 2, Fail), read("b", FinalRead, 3)}, "impossible-read"},
+		{"no final read", [][]Op[int]{add("a", 1, Ok), read("b", "read", 1)}, "no-final-read"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := CheckCounter(sequence(slices.Concat(tt.ops...)...))
+			switch {
+			case tt.want == "" && !res.Valid:
+				t.Fatalf("got %v, want valid", res)
+			case tt.want != "" && !slices.Contains(kinds(res), tt.want):
+				t.Fatalf("got %v, want a %s anomaly", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCounterConcurrentAdd(t *testing.T) {
+	// The add is still running when the read completes, so the read may or
+	// may not include it.
+	for _, v := range []int{0, 2} {
+		h := sequence(
+			invoke("a", "add", 2),
+			invoke("b", "read", 0),
+			complete("b", Ok, "read", v),
+			complete("a", Ok, "add", 2),
+			invoke("b", FinalRead, 0),
+			complete("b", Ok, FinalRead, 2),
+		)
+		if res := CheckCounter(h); !res.Valid {
+			t.Errorf("read %d: got %v, want valid", v, res)
+		}
+	}
+}
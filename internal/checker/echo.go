@@ -0,0 +1,14 @@
+package checker
+
+import "fmt"
+
+// CheckEcho checks that every acknowledged echo returned the string it sent.
+func CheckEcho(h History[string]) Result {
+	res := newResult()
+	for _, c := range h.calls() {
+		if c.ok() && c.complete.Value != c.invoke.Value {
+			res.add("mismatch", fmt.Sprintf("sent %q but got %q back", c.invoke.Value, c.complete.Value), c.complete)
+		}
+	}
+	return res
+}
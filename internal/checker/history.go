@@ -0,0 +1,180 @@
+// Package checker verifies recorded client histories against the invariants
+// of the Maelstrom workloads.
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+)
+
+// OpType is the kind of history entry, following Jepsen's conventions.
+type OpType string
+
+const (
+	// Invoke marks the start of an operation.
+	Invoke OpType = "invoke"
+	// Ok marks an operation that definitely happened.
+	Ok OpType = "ok"
+	// Fail marks an operation that definitely did not happen.
+	Fail OpType = "fail"
+	// Info marks an operation whose outcome is unknown, e.g. a timeout.
+	Info OpType = "info"
+)
+
+// FinalRead is the F of the reads performed once the workload has stopped,
+// every partition has healed and the nodes have had time to recover. The
+// broadcast and counter checkers require these reads to reflect every
+// acknowledged operation; ordinary reads may still be catching up.
+const FinalRead = "final-read"
+
+// Op is one entry of a history. An operation is recorded as an Invoke entry
+// followed, for the same process, by an Ok, Fail or Info entry.
+type Op[V any] struct {
+	Process string        `json:"process"`
+	Type    OpType        `json:"type"`
+	F       string        `json:"f"`
+	Time    time.Duration `json:"time"`
+	Value   V             `json:"value"`
+	Index   int           `json:"index"`
+}
+
+func (op Op[V]) String() string {
+	return fmt.Sprintf("#%d %s %s %s %+v at %s", op.Index, op.Process, op.Type, op.F, op.Value, op.Time)
+}
+
+// History is a sequence of operations ordered by time.
+type History[V any] []Op[V]
+
+// call pairs an invocation with its completion. Operations that never
+// completed are treated as Info.
+type call[V any] struct {
+	invoke   Op[V]
+	complete Op[V]
+}
+
+func (c call[V]) ok() bool {
+	return c.complete.Type == Ok
+}
+
+// happensBefore reports whether c completed before other was invoked. Calls
+// with an unknown outcome never complete.
+func (c call[V]) happensBefore(other call[V]) bool {
+	return c.complete.Type != Info && c.complete.Time < other.invoke.Time
+}
+
+func (h History[V]) calls() []call[V] {
+	pending := make(map[string]Op[V])
+	var calls []call[V]
+	for _, op := range h {
+		if op.Type == Invoke {
+			pending[op.Process] = op
+			continue
+		}
+		inv, ok := pending[op.Process]
+		if !ok {
+			continue
+		}
+		delete(pending, op.Process)
+		calls = append(calls, call[V]{inv, op})
+	}
+
+	for _, inv := range pending {
+		info := inv
+		info.Type = Info
+		calls = append(calls, call[V]{inv, info})
+	}
+	sort.SliceStable(calls, func(i, j int) bool {
+		return calls[i].invoke.Index < calls[j].invoke.Index
+	})
+	return calls
+}
+
+// Recorder builds a History from concurrent clients.
+type Recorder[V any] struct {
+	clk   clock.Clock
+	start time.Time
+
+	mu      sync.Mutex
+	history History[V]
+}
+
+// NewRecorder returns a recorder that timestamps operations with clk.
+func NewRecorder[V any](clk clock.Clock) *Recorder[V] {
+	return &Recorder[V]{clk: clk, start: clk.Now()}
+}
+
+// Invoke records the start of operation f by process and returns a function
+// that records its completion.
+func (r *Recorder[V]) Invoke(process, f string, value V) func(typ OpType, value V) {
+	r.record(Op[V]{Process: process, Type: Invoke, F: f, Value: value})
+	return func(typ OpType, value V) {
+		r.record(Op[V]{Process: process, Type: typ, F: f, Value: value})
+	}
+}
+
+// History returns the operations recorded so far.
+func (r *Recorder[V]) History() History[V] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(History[V](nil), r.history...)
+}
+
+func (r *Recorder[V]) record(op Op[V]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	op.Time = r.clk.Now().Sub(r.start)
+	op.Index = len(r.history)
+	r.history = append(r.history, op)
+}
+
+// Anomaly is a violation of a workload invariant.
+type Anomaly struct {
+	Kind        string
+	Explanation string
+	Ops         []string
+}
+
+func (a Anomaly) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", a.Kind, a.Explanation)
+	for _, op := range a.Ops {
+		fmt.Fprintf(&b, "\n\t%s", op)
+	}
+	return b.String()
+}
+
+// Result is the outcome of checking a history.
+type Result struct {
+	Valid     bool
+	Anomalies []Anomaly
+}
+
+func (r Result) String() string {
+	if r.Valid {
+		return "valid"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid: %d anomalies", len(r.Anomalies))
+	for _, a := range r.Anomalies {
+		fmt.Fprintf(&b, "\n%s", a)
+	}
+	return b.String()
+}
+
+func (r *Result) add(kind, explanation string, ops ...fmt.Stringer) {
+	a := Anomaly{Kind: kind, Explanation: explanation}
+	for _, op := range ops {
+		a.Ops = append(a.Ops, op.String())
+	}
+	r.Anomalies = append(r.Anomalies, a)
+	r.Valid = false
+}
+
+func newResult() Result {
+	return Result{Valid: true}
+}
@@ -0,0 +1,22 @@
+package checker
+
+import "time"
+
+// sequence numbers ops in the order given, one nanosecond apart, so that
+// the tests can interleave the invocations and completions of processes.
+func sequence[V any](ops ...Op[V]) History[V] {
+	h := make(History[V], len(ops))
+	for i, op := range ops {
+		op.Time, op.Index = time.Duration(i), i
+		h[i] = op
+	}
+	return h
+}
+
+func invoke[V any](process, f string, value V) Op[V] {
+	return Op[V]{Process: process, Type: Invoke, F: f, Value: value}
+}
+
+func complete[V any](process string, typ OpType, f string, value V) Op[V] {
+	return Op[V]{Process: process, Type: typ, F: f, Value: value}
+}
@@ -0,0 +1,297 @@
+package checker
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// KafkaValue is the value of a kafka workload operation. Invocations carry
+// the request fields and completions add the response fields.
+type KafkaValue struct {
+	// send
+	Key    string `json:"key,omitempty"`
+	Msg    int    `json:"msg"`
+	Offset int    `json:"offset"`
+
+	// poll and commit_offsets requests, list_committed_offsets responses
+	Offsets map[string]int `json:"offsets,omitempty"`
+
+	// list_committed_offsets requests
+	Keys []string `json:"keys,omitempty"`
+
+	// poll responses
+	Msgs map[string][][2]int `json:"msgs,omitempty"`
+}
+
+// CheckKafka checks a kafka workload history for:
+//   - inconsistent-offsets: two messages at the same offset of a log;
+//   - duplicate: one message at two offsets of a log;
+//   - nonmonotonic-send: a send assigned an offset no greater than one
+//     acknowledged before it was invoked;
+//   - nonmonotonic-poll: a poll returning offsets out of order;
+//   - poll-skip: a poll skipping over an offset acknowledged before it was
+//     invoked;
+//   - lost-write: an acknowledged send never returned by any poll, even
+//     though later polls read past it;
+//   - committed-offset-regression: list_committed_offsets returning less than
+//     an offset committed or listed before it was invoked.
+func CheckKafka(h History[KafkaValue]) Result {
+	res := newResult()
+	calls := h.calls()
+
+	sends := make(map[string][]call[KafkaValue])
+	var polls, commits, lists []call[KafkaValue]
+	for _, c := range calls {
+		if !c.ok() {
+			continue
+		}
+		switch c.invoke.F {
+		case "send":
+			sends[c.invoke.Value.Key] = append(sends[c.invoke.Value.Key], c)
+		case "poll":
+			polls = append(polls, c)
+		case "commit_offsets":
+			commits = append(commits, c)
+		case "list_committed_offsets":
+			lists = append(lists, c)
+		}
+	}
+
+	checkKafkaOffsets(&res, sends, polls)
+	checkKafkaSends(&res, sends)
+	checkKafkaPolls(&res, sends, polls)
+	checkKafkaCommits(&res, commits, lists)
+	return res
+}
+
+type logEntry struct {
+	value int
+	op    Op[KafkaValue]
+}
+
+func checkKafkaOffsets(res *Result, sends map[string][]call[KafkaValue], polls []call[KafkaValue]) {
+	offsets := make(map[string]map[int]logEntry)
+	msgs := make(map[string]map[int]logEntry)
+	observe := func(key string, offset, msg int, op Op[KafkaValue]) {
+		if offsets[key] == nil {
+			offsets[key] = make(map[int]logEntry)
+			msgs[key] = make(map[int]logEntry)
+		}
+		if prev, ok := offsets[key][offset]; ok && prev.value != msg {
+			res.add("inconsistent-offsets",
+				fmt.Sprintf("key %s offset %d holds both %d and %d", key, offset, prev.value, msg), prev.op, op)
+		} else if !ok {
+			offsets[key][offset] = logEntry{msg, op}
+		}
+		if prev, ok := msgs[key][msg]; ok && prev.value != offset {
+			res.add("duplicate",
+				fmt.Sprintf("key %s message %d is at both offset %d and %d", key, msg, prev.value, offset), prev.op, op)
+		} else if !ok {
+			msgs[key][msg] = logEntry{offset, op}
+		}
+	}
+
+	for _, key := range sortedKeys(sends) {
+		for _, c := range sends[key] {
+			observe(key, c.complete.Value.Offset, c.invoke.Value.Msg, c.complete)
+		}
+	}
+	for _, c := range polls {
+		for _, key := range sortedKeys(c.complete.Value.Msgs) {
+			for _, m := range c.complete.Value.Msgs[key] {
+				observe(key, m[0], m[1], c.complete)
+			}
+		}
+	}
+}
+
+func checkKafkaSends(res *Result, sends map[string][]call[KafkaValue]) {
+	for _, key := range sortedKeys(sends) {
+		before := newPrefixMax()
+		for _, c := range sends[key] {
+			before.add(c.complete.Time, c.complete.Value.Offset, c.complete)
+		}
+		before.build()
+		for _, c := range sends[key] {
+			if prev, ok := before.max(c.invoke.Time); ok && c.complete.Value.Offset <= prev.value {
+				res.add("nonmonotonic-send",
+					fmt.Sprintf("key %s send got offset %d after a send got %d", key, c.complete.Value.Offset, prev.value),
+					prev.op, c.complete)
+			}
+		}
+	}
+}
+
+func checkKafkaPolls(res *Result, sends map[string][]call[KafkaValue], polls []call[KafkaValue]) {
+	acked := make(map[string][]call[KafkaValue], len(sends))
+	for key, cs := range sends {
+		cs = slices.Clone(cs)
+		sort.Slice(cs, func(i, j int) bool { return cs[i].complete.Value.Offset < cs[j].complete.Value.Offset })
+		acked[key] = cs
+	}
+
+	seen := make(map[string]map[int]bool)
+	passed := make(map[string][]call[KafkaValue])
+	for _, p := range polls {
+		for _, key := range sortedKeys(p.complete.Value.Msgs) {
+			msgs := p.complete.Value.Msgs[key]
+			if seen[key] == nil {
+				seen[key] = make(map[int]bool)
+			}
+
+			prev := p.invoke.Value.Offsets[key] - 1
+			for _, m := range msgs {
+				seen[key][m[0]] = true
+				if m[0] <= prev {
+					res.add("nonmonotonic-poll",
+						fmt.Sprintf("key %s poll returned offset %d after %d", key, m[0], prev), p.complete)
+					prev = m[0]
+					continue
+				}
+				for _, s := range ackedBetween(acked[key], prev, m[0]) {
+					if s.happensBefore(p) {
+						res.add("poll-skip",
+							fmt.Sprintf("key %s poll skipped from offset %d to %d over acknowledged offset %d",
+								key, prev, m[0], s.complete.Value.Offset),
+							s.complete, p.complete)
+						break
+					}
+				}
+				prev = m[0]
+			}
+			if len(msgs) > 0 {
+				passed[key] = append(passed[key], p)
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(acked) {
+		for _, s := range acked[key] {
+			offset := s.complete.Value.Offset
+			if seen[key][offset] {
+				continue
+			}
+			for _, p := range passed[key] {
+				msgs := p.complete.Value.Msgs[key]
+				if s.happensBefore(p) && p.invoke.Value.Offsets[key] <= offset && msgs[len(msgs)-1][0] > offset {
+					res.add("lost-write",
+						fmt.Sprintf("key %s offset %d was acknowledged but never polled", key, offset),
+						s.complete, p.complete)
+					break
+				}
+			}
+		}
+	}
+}
+
+// ackedBetween returns the sends, sorted by offset, whose offsets lie strictly
+// between lo and hi.
+func ackedBetween(sends []call[KafkaValue], lo, hi int) []call[KafkaValue] {
+	i := sort.Search(len(sends), func(i int) bool { return sends[i].complete.Value.Offset > lo })
+	j := sort.Search(len(sends), func(i int) bool { return sends[i].complete.Value.Offset >= hi })
+	if i >= j {
+		return nil
+	}
+	return sends[i:j]
+}
+
+func checkKafkaCommits(res *Result, commits, lists []call[KafkaValue]) {
+	committed := make(map[string]*prefixMax)
+	for _, c := range commits {
+		for key, offset := range c.invoke.Value.Offsets {
+			if committed[key] == nil {
+				committed[key] = newPrefixMax()
+			}
+			committed[key].add(c.complete.Time, offset, c.complete)
+		}
+	}
+	for _, c := range lists {
+		for key, offset := range c.complete.Value.Offsets {
+			if committed[key] == nil {
+				committed[key] = newPrefixMax()
+			}
+			committed[key].add(c.complete.Time, offset, c.complete)
+		}
+	}
+	for _, pm := range committed {
+		pm.build()
+	}
+
+	for _, c := range lists {
+		for _, key := range c.invoke.Value.Keys {
+			pm, ok := committed[key]
+			if !ok {
+				continue
+			}
+			prev, ok := pm.max(c.invoke.Time)
+			if !ok {
+				continue
+			}
+			offset, listed := c.complete.Value.Offsets[key]
+			if !listed || offset < prev.value {
+				res.add("committed-offset-regression",
+					fmt.Sprintf("key %s committed offset went back from %d to %s", key, prev.value, listedOffset(offset, listed)),
+					prev.op, c.complete)
+			}
+		}
+	}
+}
+
+func listedOffset(offset int, ok bool) string {
+	if !ok {
+		return "nothing"
+	}
+	return fmt.Sprint(offset)
+}
+
+// prefixMax answers "what is the largest value recorded before time t"
+// queries.
+type prefixMax struct {
+	entries []stamped
+	maxes   []stamped
+}
+
+type stamped struct {
+	at    time.Duration
+	value int
+	op    fmt.Stringer
+}
+
+func newPrefixMax() *prefixMax {
+	return &prefixMax{}
+}
+
+func (pm *prefixMax) add(at time.Duration, value int, op fmt.Stringer) {
+	pm.entries = append(pm.entries, stamped{at, value, op})
+}
+
+func (pm *prefixMax) build() {
+	sort.SliceStable(pm.entries, func(i, j int) bool { return pm.entries[i].at < pm.entries[j].at })
+	pm.maxes = make([]stamped, len(pm.entries))
+	for i, e := range pm.entries {
+		if i == 0 || e.value > pm.maxes[i-1].value {
+			pm.maxes[i] = e
+		} else {
+			pm.maxes[i] = pm.maxes[i-1]
+		}
+	}
+}
+
+func (pm *prefixMax) max(before time.Duration) (stamped, bool) {
+	i := sort.Search(len(pm.entries), func(i int) bool { return pm.entries[i].at >= before })
+	if i == 0 {
+		return stamped{}, false
+	}
+	return pm.maxes[i-1], true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
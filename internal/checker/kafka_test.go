@@ -0,0 +1,131 @@
+package checker
+
+import (
+	"slices"
+	"testing"
+)
+
+// send, poll, commit and list return the invocation and completion of an
+// operation that no other operation overlaps.
+func send(key string, msg, offset int) []Op[KafkaValue] {
+	return []Op[KafkaValue]{
+		invoke("a", "send", KafkaValue{Key: key, Msg: msg}),
+		complete("a", Ok, "send", KafkaValue{Key: key, Msg: msg, Offset: offset}),
+	}
+}
+
+func poll(offsets map[string]int, msgs map[string][][2]int) []Op[KafkaValue] {
+	return []Op[KafkaValue]{
+		invoke("b", "poll", KafkaValue{Offsets: offsets}),
+		complete("b", Ok, "poll", KafkaValue{Offsets: offsets, Msgs: msgs}),
+	}
+}
+
+func commit(offsets map[string]int) []Op[KafkaValue] {
+	return []Op[KafkaValue]{
+		invoke("b", "commit_offsets", KafkaValue{Offsets: offsets}),
+		complete("b", Ok, "commit_offsets", KafkaValue{Offsets: offsets}),
+	}
+}
+
+func list(keys []string, offsets map[string]int) []Op[KafkaValue] {
+	return []Op[KafkaValue]{
+		invoke("c", "list_committed_offsets", KafkaValue{Keys: keys}),
+		complete("c", Ok, "list_committed_offsets", KafkaValue{Keys: keys, Offsets: offsets}),
+	}
+}
+
+func TestCheckKafka(t *testing.T) {
+	from0 := map[string]int{"k": 0}
+	for _, tt := range []struct {
+		name string
+		ops  [][]Op[KafkaValue]
+		want string
+	}{
+		{"valid", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 11, 1),
+			poll(from0, map[string][][2]int{"k": {{0, 10}, {1, 11}}}),
+			commit(map[string]int{"k": 1}),
+			list([]string{"k"}, map[string]int{"k": 1}),
+		}, ""},
+		{"gaps between offsets", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 11, 5),
+			poll(from0, map[string][][2]int{"k": {{0, 10}, {5, 11}}}),
+		}, ""},
+		{"poll from the middle", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 11, 1),
+			poll(map[string]int{"k": 1}, map[string][][2]int{"k": {{1, 11}}}),
+		}, ""},
+		{"commit moves forward", [][]Op[KafkaValue]{
+			commit(map[string]int{"k": 1}),
+			commit(map[string]int{"k": 3}),
+			list([]string{"k"}, map[string]int{"k": 3}),
+		}, ""},
+		{"two messages at one offset", [][]Op[KafkaValue]{
+			send("k", 10, 0),
+			poll(from0, map[string][][2]int{"k": {{0, 99}}}),
+		}, "inconsistent-offsets"},
+		{"one message at two offsets", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 10, 1),
+		}, "duplicate"},
+		{"send goes back", [][]Op[KafkaValue]{
+			send("k", 10, 1), send("k", 11, 0),
+		}, "nonmonotonic-send"},
+		{"poll goes back", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 11, 1),
+			poll(from0, map[string][][2]int{"k": {{1, 11}, {0, 10}}}),
+		}, "nonmonotonic-poll"},
+		{"poll returns an offset below the request", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 11, 1),
+			poll(map[string]int{"k": 1}, map[string][][2]int{"k": {{0, 10}, {1, 11}}}),
+		}, "nonmonotonic-poll"},
+		{"poll skips an acknowledged offset", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 11, 1), send("k", 12, 2),
+			poll(from0, map[string][][2]int{"k": {{0, 10}, {2, 12}}}),
+		}, "poll-skip"},
+		{"acknowledged send never polled", [][]Op[KafkaValue]{
+			send("k", 10, 0), send("k", 11, 1), send("k", 12, 2),
+			poll(from0, map[string][][2]int{"k": {{0, 10}, {2, 12}}}),
+		}, "lost-write"},
+		{"committed offset goes back", [][]Op[KafkaValue]{
+			commit(map[string]int{"k": 5}),
+			list([]string{"k"}, map[string]int{"k": 3}),
+		}, "committed-offset-regression"},
+		{"committed offset lost", [][]Op[KafkaValue]{
+			commit(map[string]int{"k": 5}),
+			list([]string{"k"}, map[string]int{}),
+		}, "committed-offset-regression"},
+		{"listed offset goes back", [][]Op[KafkaValue]{
+			commit(map[string]int{"k": 5}),
+			list([]string{"k"}, map[string]int{"k": 5}),
+			commit(map[string]int{"k": 4}),
+			list([]string{"k"}, map[string]int{"k": 4}),
+		}, "committed-offset-regression"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res := CheckKafka(sequence(slices.Concat(tt.ops...)...))
+			switch {
+			case tt.want == "" && !res.Valid:
+				t.Fatalf("got %v, want valid", res)
+			case tt.want != "" && !slices.Contains(kinds(res), tt.want):
+				t.Fatalf("got %v, want a %s anomaly", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckKafkaConcurrentSend(t *testing.T) {
+	// The send is acknowledged only after the poll completes, so the poll may
+	// skip over its offset.
+	h := sequence(
+		invoke("a", "send", KafkaValue{Key: "k", Msg: 10}),
+		invoke("d", "send", KafkaValue{Key: "k", Msg: 11}),
+		complete("a", Ok, "send", KafkaValue{Key: "k", Msg: 10, Offset: 0}),
+		invoke("b", "poll", KafkaValue{Offsets: map[string]int{"k": 0}}),
+		complete("b", Ok, "poll", KafkaValue{Offsets: map[string]int{"k": 0}, Msgs: map[string][][2]int{"k": {{0, 10}, {2, 12}}}}),
+		complete("d", Ok, "send", KafkaValue{Key: "k", Msg: 11, Offset: 1}),
+	)
+	if res := CheckKafka(h); slices.Contains(kinds(res), "poll-skip") {
+		t.Fatalf("got %v, want no poll-skip", res)
+	}
+}
@@ -0,0 +1,454 @@
+package checker
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// TxnValue is the value of a txn operation: its micro-operations. Values
+// are nil for reads in invocations.
+type TxnValue []proto.Op
+
+// Consistency models understood by CheckTxn, and the anomalies they forbid.
+var txnModels = map[string][]string{
+	"read-uncommitted": {"G0"},
+	"read-committed":   {"G0", "G1a", "G1b", "G1c"},
+	"serializable":     {"G0", "G1a", "G1b", "G1c", "G-single", "G2"},
+}
+
+// CheckTxn checks a txn-rw-register history against a consistency model:
+// "read-uncommitted", "read-committed" or "serializable". Like Elle, it
+// assumes that every value is written at most once per key, and infers
+// write-write (ww), write-read (wr) and read-write (rw) dependencies between
+// transactions from the values they read. Registers do not record the order
+// of their versions, so ww dependencies are only known where a transaction
+// read a key before overwriting it, or read it twice and saw it change; a
+// cycle of blind writes that no transaction observed goes unreported. It
+// reports:
+//   - internal: a transaction that does not read its own writes;
+//   - G0 (dirty write): a cycle of ww dependencies;
+//   - G1a (aborted read): a read of a value written by a failed transaction;
+//   - G1b (intermediate read): a read of a value that its writer later
+//     overwrote in the same transaction;
+//   - G1c (circular information flow): a cycle of ww and wr dependencies;
+//   - G-single: a cycle with exactly one rw dependency;
+//   - G2: a cycle with several rw dependencies.
+//
+// Only the anomalies forbidden by the model make the result invalid.
+func CheckTxn(h History[TxnValue], model string) (Result, error) {
+	forbidden, ok := txnModels[model]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown consistency model %q", model)
+	}
+
+	res := newResult()
+	found := txnAnomalies(h)
+	for _, a := range found {
+		if a.Kind == "internal" || slices.Contains(forbidden, a.Kind) {
+			res.Anomalies = append(res.Anomalies, a)
+			res.Valid = false
+		}
+	}
+	return res, nil
+}
+
+type txnWrite struct {
+	txn   int
+	final bool
+}
+
+type txnKeyValue struct {
+	key   int
+	value int
+}
+
+type depKind int
+
+const (
+	ww depKind = 1 << iota
+	wr
+	rw
+)
+
+func (k depKind) String() string {
+	switch k {
+	case ww:
+		return "ww"
+	case wr:
+		return "wr"
+	default:
+		return "rw"
+	}
+}
+
+type txnGraph struct {
+	txns  []call[TxnValue]
+	edges []map[int]depKind
+}
+
+func (g *txnGraph) link(from, to int, kind depKind) {
+	if from == to {
+		return
+	}
+	g.edges[from][to] |= kind
+}
+
+func txnAnomalies(h History[TxnValue]) []Anomaly {
+	var res Result
+	g := &txnGraph{}
+	var failed []call[TxnValue]
+	for _, c := range h.calls() {
+		if c.invoke.F != "txn" {
+			continue
+		}
+		switch c.complete.Type {
+		case Ok, Info:
+			g.txns = append(g.txns, c)
+		case Fail:
+			failed = append(failed, c)
+		}
+	}
+	g.edges = make([]map[int]depKind, len(g.txns))
+	for i := range g.edges {
+		g.edges[i] = make(map[int]depKind)
+	}
+
+	// Index writes. Info transactions may have committed, so their writes
+	// count; their reads are unknown.
+	writes := make(map[txnKeyValue]txnWrite)
+	for i, c := range g.txns {
+		ops := c.complete.Value
+		if c.complete.Type == Info {
+			ops = c.invoke.Value
+		}
+		last := make(map[int]int)
+		for j, op := range ops {
+			if op.Name == "w" && op.Value != nil {
+				last[op.Key] = j
+			}
+		}
+		for j, op := range ops {
+			if op.Name == "w" && op.Value != nil {
+				writes[txnKeyValue{op.Key, *op.Value}] = txnWrite{i, last[op.Key] == j}
+			}
+		}
+	}
+	aborted := make(map[txnKeyValue]call[TxnValue])
+	for _, c := range failed {
+		for _, op := range c.invoke.Value {
+			if op.Name == "w" && op.Value != nil {
+				aborted[txnKeyValue{op.Key, *op.Value}] = c
+			}
+		}
+	}
+
+	// readers holds, for each version of a key, the transactions that read
+	// it without having written the key first.
+	readers := make(map[txnVersion][]int)
+
+	for i, c := range g.txns {
+		if c.complete.Type != Ok {
+			continue
+		}
+		own := make(map[int]*int)
+		seen := make(map[int]*int)
+		for _, op := range c.complete.Value {
+			if op.Name == "w" {
+				own[op.Key] = op.Value
+				continue
+			}
+
+			if v, ok := own[op.Key]; ok {
+				if !equalValues(v, op.Value) {
+					res.add("internal",
+						fmt.Sprintf("T%d read %s after writing %s", c.invoke.Index, op, formatValue(v)), c.complete)
+				}
+				continue
+			}
+
+			readers[versionOf(op)] = append(readers[versionOf(op)], i)
+			prev, reread := seen[op.Key]
+			seen[op.Key] = op.Value
+			if op.Value == nil {
+				continue
+			}
+			// A transaction that reads a key again and sees another value
+			// saw the key overwritten: the writer of the first value
+			// precedes the writer of the second (ww). This orders blind
+			// writes, which no transaction reads before overwriting.
+			if reread && prev != nil && *prev != *op.Value {
+				first, ok1 := writes[txnKeyValue{op.Key, *prev}]
+				second, ok2 := writes[txnKeyValue{op.Key, *op.Value}]
+				if ok1 && ok2 {
+					g.link(first.txn, second.txn, ww)
+				}
+			}
+
+			kv := txnKeyValue{op.Key, *op.Value}
+			if a, ok := aborted[kv]; ok {
+				res.add("G1a",
+					fmt.Sprintf("T%d read %s, written by failed T%d", c.invoke.Index, op, a.invoke.Index),
+					a.complete, c.complete)
+			}
+			w, ok := writes[kv]
+			if !ok {
+				continue
+			}
+			if !w.final {
+				res.add("G1b",
+					fmt.Sprintf("T%d read %s, an intermediate write of T%d", c.invoke.Index, op, g.txns[w.txn].invoke.Index),
+					g.txns[w.txn].complete, c.complete)
+			}
+			g.link(w.txn, i, wr)
+		}
+	}
+
+	// A transaction that read a key before writing it overwrote the version
+	// it read: that version's writer precedes it (ww), and so does every
+	// other transaction that read the version (rw).
+	for i, c := range g.txns {
+		if c.complete.Type != Ok {
+			continue
+		}
+		for _, key := range writtenKeys(c.complete.Value) {
+			r, ok := readBeforeWrite(c.complete.Value, key)
+			if !ok {
+				continue
+			}
+			if r.Value != nil {
+				if w, ok := writes[txnKeyValue{key, *r.Value}]; ok {
+					g.link(w.txn, i, ww)
+				}
+			}
+			for _, reader := range readers[versionOf(r)] {
+				g.link(reader, i, rw)
+			}
+		}
+	}
+
+	g.cycles(&res)
+	return res.Anomalies
+}
+
+// txnVersion identifies the value of a key, or its initial nil value.
+type txnVersion struct {
+	key   int
+	value int
+	isNil bool
+}
+
+func versionOf(op proto.Op) txnVersion {
+	if op.Value == nil {
+		return txnVersion{key: op.Key, isNil: true}
+	}
+	return txnVersion{key: op.Key, value: *op.Value}
+}
+
+// writtenKeys returns the keys that ops writes, in order of first write.
+func writtenKeys(ops []proto.Op) []int {
+	var keys []int
+	for _, op := range ops {
+		if op.Name == "w" && !slices.Contains(keys, op.Key) {
+			keys = append(keys, op.Key)
+		}
+	}
+	return keys
+}
+
+// readBeforeWrite returns the first operation on key if it is a read.
+func readBeforeWrite(ops []proto.Op, key int) (proto.Op, bool) {
+	for _, op := range ops {
+		if op.Key == key {
+			return op, op.Name == "r"
+		}
+	}
+	return proto.Op{}, false
+}
+
+func equalValues(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func formatValue(v *int) string {
+	if v == nil {
+		return "nil"
+	}
+	return fmt.Sprint(*v)
+}
+
+func (g *txnGraph) cycles(res *Result) {
+	type class struct {
+		kind    string
+		allowed depKind
+		pivot   depKind
+		back    depKind
+	}
+	classes := []class{
+		{"G0", ww, ww, ww},
+		{"G1c", ww | wr, wr, ww | wr},
+		{"G-single", ww | wr | rw, rw, ww | wr},
+		{"G2", ww | wr | rw, rw, ww | wr | rw},
+	}
+
+	reported := make(map[string]bool)
+	for _, cl := range classes {
+		for _, scc := range g.sccs(cl.allowed) {
+			if len(scc) < 2 {
+				continue
+			}
+			in := make(map[int]bool, len(scc))
+			for _, t := range scc {
+				in[t] = true
+			}
+			cycle := g.findCycle(scc, in, cl.pivot, cl.back)
+			if cycle == nil {
+				continue
+			}
+			key := cycleKey(cycle)
+			if reported[key] {
+				continue
+			}
+			reported[key] = true
+			g.report(res, cl.kind, cycle)
+		}
+	}
+}
+
+// findCycle looks for a cycle in the component that starts with a pivot edge
+// and returns to its start using back edges only.
+func (g *txnGraph) findCycle(scc []int, in map[int]bool, pivot, back depKind) []int {
+	for _, from := range scc {
+		for _, to := range sortedEdges(g.edges[from]) {
+			if !in[to] || g.edges[from][to]&pivot == 0 {
+				continue
+			}
+			if path := g.path(to, from, in, back); path != nil {
+				return append([]int{from}, path...)
+			}
+		}
+	}
+	return nil
+}
+
+// path returns a shortest path from src to dst within in using edges of the
+// given kinds.
+func (g *txnGraph) path(src, dst int, in map[int]bool, kinds depKind) []int {
+	prev := map[int]int{src: -1}
+	queue := []int{src}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		if t == dst {
+			var p []int
+			for ; t != -1; t = prev[t] {
+				p = append(p, t)
+			}
+			slices.Reverse(p)
+			return p
+		}
+		for _, next := range sortedEdges(g.edges[t]) {
+			if _, seen := prev[next]; seen || !in[next] || g.edges[t][next]&kinds == 0 {
+				continue
+			}
+			prev[next] = t
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
+
+func (g *txnGraph) report(res *Result, kind string, cycle []int) {
+	var b strings.Builder
+	ops := make([]fmt.Stringer, 0, len(cycle))
+	for i, t := range cycle[:len(cycle)-1] {
+		next := cycle[i+1]
+		fmt.Fprintf(&b, "T%d -%s-> ", g.txns[t].invoke.Index, edgeLabel(g.edges[t][next]))
+		ops = append(ops, g.txns[t].complete)
+	}
+	fmt.Fprintf(&b, "T%d", g.txns[cycle[len(cycle)-1]].invoke.Index)
+	res.add(kind, b.String(), ops...)
+}
+
+func edgeLabel(kinds depKind) string {
+	var labels []string
+	for _, k := range []depKind{ww, wr, rw} {
+		if kinds&k != 0 {
+			labels = append(labels, k.String())
+		}
+	}
+	return strings.Join(labels, ",")
+}
+
+func cycleKey(cycle []int) string {
+	nodes := slices.Clone(cycle[:len(cycle)-1])
+	slices.Sort(nodes)
+	return fmt.Sprint(nodes)
+}
+
+// sccs returns the strongly connected components of the graph restricted to
+// edges of the given kinds, using Tarjan's algorithm.
+func (g *txnGraph) sccs(kinds depKind) [][]int {
+	index := make([]int, len(g.txns))
+	low := make([]int, len(g.txns))
+	onStack := make([]bool, len(g.txns))
+	for i := range index {
+		index[i] = -1
+	}
+	var stack []int
+	var sccs [][]int
+	next := 0
+
+	var visit func(v int)
+	visit = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range sortedEdges(g.edges[v]) {
+			if g.edges[v][w]&kinds == 0 {
+				continue
+			}
+			if index[w] == -1 {
+				visit(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] == index[v] {
+			var scc []int
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			slices.Sort(scc)
+			sccs = append(sccs, scc)
+		}
+	}
+	for v := range g.txns {
+		if index[v] == -1 {
+			visit(v)
+		}
+	}
+	return sccs
+}
+
+func sortedEdges(edges map[int]depKind) []int {
+	to := make([]int, 0, len(edges))
+	for t := range edges {
+		to = append(to, t)
+	}
+	slices.Sort(to)
+	return to
+}
@@ -0,0 +1,105 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// txnHistory builds a history of transactions that run one after another,
+// each by its own process. Every transaction is given as the micro-operations
+// it completed with.
+func txnHistory(txns ...TxnValue) History[TxnValue] {
+	var h History[TxnValue]
+	for i, txn := range txns {
+		process := string(rune('a' + i))
+		invoke := make(TxnValue, len(txn))
+		for j, op := range txn {
+			invoke[j] = op
+			if op.Name == "r" {
+				invoke[j].Value = nil
+			}
+		}
+		h = append(h,
+			Op[TxnValue]{Process: process, Type: Invoke, F: "txn", Value: invoke, Time: time.Duration(2 * i), Index: 2 * i},
+			Op[TxnValue]{Process: process, Type: Ok, F: "txn", Value: txn, Time: time.Duration(2*i + 1), Index: 2*i + 1})
+	}
+	return h
+}
+
+func r(key, value int) proto.Op { return proto.Op{Name: "r", Key: key, Value: &value} }
+func w(key, value int) proto.Op { return proto.Op{Name: "w", Key: key, Value: &value} }
+
+func kinds(res Result) []string {
+	var kinds []string
+	for _, a := range res.Anomalies {
+		kinds = append(kinds, a.Kind)
+	}
+	return kinds
+}
+
+func TestCheckTxnBlindWriteG0(t *testing.T) {
+	// T0 and T1 blindly write x and y. T2 sees x go from T0's value to
+	// T1's, and T3 sees y go from T1's value to T0's, so the writes were
+	// interleaved.
+	h := txnHistory(
+		TxnValue{w(1, 1), w(2, 1)},
+		TxnValue{w(1, 2), w(2, 2)},
+		TxnValue{r(1, 1), r(1, 2)},
+		TxnValue{r(2, 2), r(2, 1)},
+	)
+
+	res, err := CheckTxn(h, "read-uncommitted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || len(res.Anomalies) != 1 || res.Anomalies[0].Kind != "G0" {
+		t.Fatalf("got %v, want one G0 anomaly", res)
+	}
+}
+
+func TestCheckTxnBlindWritesInOrder(t *testing.T) {
+	h := txnHistory(
+		TxnValue{w(1, 1), w(2, 1)},
+		TxnValue{w(1, 2), w(2, 2)},
+		TxnValue{r(1, 1), r(1, 2)},
+		TxnValue{r(2, 1), r(2, 2)},
+	)
+
+	res, err := CheckTxn(h, "serializable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid {
+		t.Fatalf("got %v, want valid", res)
+	}
+}
+
+func TestCheckTxnReadBeforeWriteG0(t *testing.T) {
+	// T1 overwrites T0's x and T0 overwrites T1's y.
+	h := txnHistory(
+		TxnValue{w(1, 1), r(2, 2), w(2, 1)},
+		TxnValue{r(1, 1), w(1, 2), w(2, 2)},
+	)
+
+	res, err := CheckTxn(h, "read-uncommitted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(res); len(got) != 1 || got[0] != "G0" {
+		t.Fatalf("got %v, want one G0 anomaly", res)
+	}
+}
+
+func TestCheckTxnInternal(t *testing.T) {
+	h := txnHistory(TxnValue{w(1, 1), w(1, 2), r(1, 1)})
+
+	res, err := CheckTxn(h, "read-uncommitted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(res); len(got) != 1 || got[0] != "internal" {
+		t.Fatalf("got %v, want one internal anomaly", res)
+	}
+}
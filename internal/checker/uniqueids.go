@@ -0,0 +1,22 @@
+package checker
+
+import "fmt"
+
+// CheckUniqueIDs checks that no two acknowledged generate operations
+// returned the same id.
+func CheckUniqueIDs(h History[any]) Result {
+	res := newResult()
+	seen := make(map[string]Op[any])
+	for _, c := range h.calls() {
+		if !c.ok() {
+			continue
+		}
+		id := fmt.Sprint(c.complete.Value)
+		if prev, ok := seen[id]; ok {
+			res.add("duplicate", fmt.Sprintf("id %s was generated twice", id), prev, c.complete)
+			continue
+		}
+		seen[id] = c.complete
+	}
+	return res
+}
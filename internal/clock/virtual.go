@@ -0,0 +1,225 @@
+package clock
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Virtual is a Clock whose time only moves when Advance or Fire is called.
+// Timers that expire at the same instant fire in the order they were created.
+type Virtual struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  timerHeap
+	nextSeq int
+}
+
+// NewVirtual returns a virtual clock set to start.
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	v.afterFunc(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (v *Virtual) Sleep(d time.Duration) {
+	<-v.After(d)
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	t := &virtualTicker{v: v, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (v *Virtual) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	tc := &timeoutCtx{Context: ctx, deadline: v.Now().Add(d)}
+	t := v.afterFunc(d, func(time.Time) {
+		tc.mu.Lock()
+		if tc.err == nil {
+			tc.err = context.DeadlineExceeded
+		}
+		tc.mu.Unlock()
+		cancel()
+	})
+	return tc, func() {
+		v.stop(t)
+		cancel()
+	}
+}
+
+// Next returns the expiry time of the earliest pending timer.
+func (v *Virtual) Next() (time.Time, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.timers) == 0 {
+		return time.Time{}, false
+	}
+	return v.timers[0].at, true
+}
+
+// Advance moves the clock to t without firing any timers. It never moves the
+// clock backwards.
+func (v *Virtual) Advance(t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t.After(v.now) {
+		v.now = t
+	}
+}
+
+// Fire advances the clock to the earliest pending timer and runs it. It
+// reports whether a timer was fired.
+func (v *Virtual) Fire() bool {
+	v.mu.Lock()
+	if len(v.timers) == 0 {
+		v.mu.Unlock()
+		return false
+	}
+	t := heap.Pop(&v.timers).(*timer)
+	t.index = -1
+	if t.at.After(v.now) {
+		v.now = t.at
+	}
+	now := v.now
+	v.mu.Unlock()
+
+	t.f(now)
+	return true
+}
+
+func (v *Virtual) afterFunc(d time.Duration, f func(now time.Time)) *timer {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	t := &timer{at: v.now.Add(d), seq: v.nextSeq, f: f}
+	v.nextSeq++
+	heap.Push(&v.timers, t)
+	return t
+}
+
+func (v *Virtual) stop(t *timer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t.index >= 0 {
+		heap.Remove(&v.timers, t.index)
+		t.index = -1
+	}
+}
+
+type virtualTicker struct {
+	v *Virtual
+	c chan time.Time
+
+	mu  sync.Mutex
+	t   *timer
+	gen int
+}
+
+func (t *virtualTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *virtualTicker) Reset(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopLocked()
+	gen := t.gen
+	var tick func(now time.Time)
+	tick = func(now time.Time) {
+		// Like time.Ticker, drop ticks for slow receivers.
+		select {
+		case t.c <- now:
+		default:
+		}
+		t.mu.Lock()
+		if t.gen == gen {
+			t.t = t.v.afterFunc(d, tick)
+		}
+		t.mu.Unlock()
+	}
+	t.t = t.v.afterFunc(d, tick)
+}
+
+func (t *virtualTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopLocked()
+}
+
+func (t *virtualTicker) stopLocked() {
+	t.gen++
+	if t.t != nil {
+		t.v.stop(t.t)
+		t.t = nil
+	}
+}
+
+type timeoutCtx struct {
+	context.Context
+	deadline time.Time
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *timeoutCtx) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *timeoutCtx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.Context.Err()
+}
+
+type timer struct {
+	at    time.Time
+	seq   int
+	f     func(now time.Time)
+	index int
+}
+
+type timerHeap []*timer
+
+func (h timerHeap) Len() int { return len(h) }
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
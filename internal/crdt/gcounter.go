@@ -0,0 +1,46 @@
+// Package crdt implements state-based counters. Every node only changes its
+// own entries, and nodes exchange their whole state and merge it with what
+// they have, so all nodes converge to the same value once they have heard
+// from each other, whatever the order and number of the exchanges.
+package crdt
+
+// GCounter is a grow-only counter. It maps node ids to the total each node
+// added, and its value is the sum of the totals. Missing entries are zero.
+// The zero value is not ready to use; make one with NewGCounter.
+type GCounter map[string]int
+
+// NewGCounter returns a counter at zero.
+func NewGCounter() GCounter {
+	return make(GCounter)
+}
+
+// Clone returns a copy of c.
+func (c GCounter) Clone() GCounter {
+	clone := make(GCounter, len(c))
+	for node, n := range c {
+		clone[node] = n
+	}
+	return clone
+}
+
+// Increment adds delta, which must not be negative, to the total of node.
+func (c GCounter) Increment(node string, delta int) {
+	c[node] += delta
+}
+
+// Merge raises every total of c to at least the one of other. Merging is
+// commutative, associative and idempotent.
+func (c GCounter) Merge(other GCounter) {
+	for node, n := range other {
+		c[node] = max(c[node], n)
+	}
+}
+
+// Value returns the sum of the totals.
+func (c GCounter) Value() int {
+	sum := 0
+	for _, n := range c {
+		sum += n
+	}
+	return sum
+}
@@ -0,0 +1,40 @@
+package crdt
+
+// PNCounter is a counter that can also be decremented. It keeps the
+// increments and the decrements in two grow-only counters, and its value is
+// their difference. The zero value is not ready to use; make one with
+// NewPNCounter.
+type PNCounter struct {
+	P GCounter `json:"p"`
+	N GCounter `json:"n"`
+}
+
+// NewPNCounter returns a counter at zero.
+func NewPNCounter() PNCounter {
+	return PNCounter{P: NewGCounter(), N: NewGCounter()}
+}
+
+// Clone returns a copy of c.
+func (c PNCounter) Clone() PNCounter {
+	return PNCounter{P: c.P.Clone(), N: c.N.Clone()}
+}
+
+// Add adds delta, which may be negative, at node.
+func (c PNCounter) Add(node string, delta int) {
+	if delta < 0 {
+		c.N.Increment(node, -delta)
+	} else {
+		c.P.Increment(node, delta)
+	}
+}
+
+// Merge merges the increments and the decrements of other into c.
+func (c PNCounter) Merge(other PNCounter) {
+	c.P.Merge(other.P)
+	c.N.Merge(other.N)
+}
+
+// Value returns the increments minus the decrements.
+func (c PNCounter) Value() int {
+	return c.P.Value() - c.N.Value()
+}
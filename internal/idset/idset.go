@@ -0,0 +1,173 @@
+// Package idset stores sets of message ids as sorted runs of consecutive
+// ids. Broadcast ids are handed out in sequence, so a node that has seen
+// most of them holds a handful of runs no matter how many ids there are.
+package idset
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// maxLen bounds the number of ids a decoded set may hold, so that a peer
+// cannot make Slice allocate unbounded memory with a few wide runs.
+const maxLen = 1 << 20
+
+// Set is a set of ints. The zero value is an empty set ready to use. A Set
+// is not safe for concurrent use.
+type Set struct {
+	// runs are sorted, disjoint and never adjacent: two runs that would
+	// touch are merged into one.
+	runs []run
+	n    int
+}
+
+// run holds the ids in [lo, hi].
+type run struct {
+	lo, hi int
+}
+
+// Of returns the set of ids.
+func Of(ids ...int) *Set {
+	s := &Set{}
+	for _, id := range ids {
+		s.Add(id)
+	}
+	return s
+}
+
+// Add adds id to s and reports whether it was missing. Adding an id that
+// extends a run costs one binary search over the runs; adding one that
+// starts a new run also shifts the runs after it, which is linear in the
+// number of runs. A tree of runs would make that logarithmic, but ids that
+// are only reordered within a window leave about as many runs as the window
+// is wide, and then a slice keeps the common in order case and Diff cheap.
+// BenchmarkAdd measures both: shuffled ids are an order of magnitude slower
+// to add than to a map, ids reordered within 1000 about as fast.
+func (s *Set) Add(id int) bool {
+	i := s.search(id)
+	if i < len(s.runs) && s.runs[i].lo <= id {
+		return false
+	}
+
+	s.n++
+	extendsPrev := i > 0 && s.runs[i-1].hi == id-1
+	extendsNext := i < len(s.runs) && s.runs[i].lo == id+1
+	switch {
+	case extendsPrev && extendsNext:
+		s.runs[i-1].hi = s.runs[i].hi
+		s.runs = slices.Delete(s.runs, i, i+1)
+	case extendsPrev:
+		s.runs[i-1].hi = id
+	case extendsNext:
+		s.runs[i].lo = id
+	default:
+		s.runs = slices.Insert(s.runs, i, run{id, id})
+	}
+	return true
+}
+
+// Contains reports whether id is in s.
+func (s *Set) Contains(id int) bool {
+	i := s.search(id)
+	return i < len(s.runs) && s.runs[i].lo <= id
+}
+
+// Len returns the number of ids in s.
+func (s *Set) Len() int {
+	return s.n
+}
+
+// Runs returns the number of runs s is stored as.
+func (s *Set) Runs() int {
+	return len(s.runs)
+}
+
+// Slice returns the ids of s in ascending order.
+func (s *Set) Slice() []int {
+	ids := make([]int, 0, s.n)
+	for _, r := range s.runs {
+		for id := r.lo; id <= r.hi; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Clone returns a copy of s.
+func (s *Set) Clone() *Set {
+	return &Set{runs: slices.Clone(s.runs), n: s.n}
+}
+
+// Diff returns the ids of s that are not in other. It walks the runs of both
+// sets once, so its cost depends on the number of runs rather than ids.
+func (s *Set) Diff(other *Set) *Set {
+	d := &Set{}
+	b := other.runs
+	for _, r := range s.runs {
+		lo := r.lo
+		for len(b) > 0 && b[0].hi < lo {
+			b = b[1:]
+		}
+		for j := 0; j < len(b) && b[j].lo <= r.hi && lo <= r.hi; j++ {
+			if b[j].lo > lo {
+				d.appendRun(run{lo, b[j].lo - 1})
+			}
+			lo = max(lo, b[j].hi+1)
+		}
+		if lo <= r.hi {
+			d.appendRun(run{lo, r.hi})
+		}
+	}
+	return d
+}
+
+// appendRun adds r, which must start after every id of s.
+func (s *Set) appendRun(r run) {
+	s.runs = append(s.runs, r)
+	s.n += r.hi - r.lo + 1
+}
+
+// search returns the index of the first run that ends at or after id.
+func (s *Set) search(id int) int {
+	return sort.Search(len(s.runs), func(i int) bool { return s.runs[i].hi >= id })
+}
+
+// MarshalJSON encodes s as a list of [lo, hi] runs.
+func (s Set) MarshalJSON() ([]byte, error) {
+	runs := make([][2]int, len(s.runs))
+	for i, r := range s.runs {
+		runs[i] = [2]int{r.lo, r.hi}
+	}
+	return json.Marshal(runs)
+}
+
+// UnmarshalJSON decodes a list of [lo, hi] runs, which must be sorted and
+// disjoint and hold at most maxLen ids in total.
+func (s *Set) UnmarshalJSON(data []byte) error {
+	var runs [][2]int
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return err
+	}
+	*s = Set{runs: make([]run, 0, len(runs))}
+	for i, r := range runs {
+		if r[0] > r[1] {
+			return fmt.Errorf("run %d: %d is after %d", i, r[0], r[1])
+		}
+		if i > 0 && r[0] <= runs[i-1][1] {
+			return fmt.Errorf("run %d: overlaps or precedes run %d", i, i-1)
+		}
+		// The difference is taken as unsigned so that it cannot overflow.
+		if uint64(r[1]-r[0]) >= uint64(maxLen-s.n) {
+			return fmt.Errorf("run %d: set holds more than %d ids", i, maxLen)
+		}
+		if i > 0 && r[0] == runs[i-1][1]+1 {
+			s.runs[len(s.runs)-1].hi = r[1]
+			s.n += r[1] - r[0] + 1
+			continue
+		}
+		s.appendRun(run{r[0], r[1]})
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package idset
+
+import (
+	"encoding/json"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ids  []int
+		runs []run
+	}{
+		{"in order", []int{1, 2, 3}, []run{{1, 3}}},
+		{"extends next", []int{3, 2, 1}, []run{{1, 3}}},
+		{"joins two runs", []int{1, 3, 2}, []run{{1, 3}}},
+		{"out of order", []int{10, 1, 5, 3, 2, 4}, []run{{1, 5}, {10, 10}}},
+		{"duplicates", []int{2, 2, 1, 2}, []run{{1, 2}}},
+		{"negative", []int{-1, 1, 0}, []run{{-1, 1}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Set{}
+			added := 0
+			for _, id := range tt.ids {
+				if s.Add(id) {
+					added++
+				}
+			}
+			if !slices.Equal(s.runs, tt.runs) {
+				t.Errorf("got runs %v, want %v", s.runs, tt.runs)
+			}
+			if s.Len() != added {
+				t.Errorf("got Len %d, want %d", s.Len(), added)
+			}
+		})
+	}
+}
+
+func TestAddRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := &Set{}
+	want := make(map[int]bool)
+	for range 10_000 {
+		id := rng.Intn(2_000)
+		if s.Add(id) == want[id] {
+			t.Fatalf("Add(%d) reported %v with the id already added: %v", id, !want[id], want[id])
+		}
+		want[id] = true
+	}
+	for id := -1; id <= 2_000; id++ {
+		if s.Contains(id) != want[id] {
+			t.Fatalf("Contains(%d) = %v, want %v", id, !want[id], want[id])
+		}
+	}
+	if s.Len() != len(want) || len(s.Slice()) != len(want) || !slices.IsSorted(s.Slice()) {
+		t.Fatalf("got %d ids, want %d sorted", s.Len(), len(want))
+	}
+	for i := 1; i < len(s.runs); i++ {
+		if s.runs[i].lo <= s.runs[i-1].hi+1 {
+			t.Fatalf("runs %v and %v are not merged", s.runs[i-1], s.runs[i])
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	s := Of(1, 2, 3, 7)
+	for id, want := range map[int]bool{0: false, 1: true, 3: true, 4: false, 6: false, 7: true, 8: false} {
+		if got := s.Contains(id); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", id, got, want)
+		}
+	}
+	if (&Set{}).Contains(0) {
+		t.Error("the empty set contains 0")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		a, b     []int
+		wantDiff []int
+	}{
+		{"empty other", []int{1, 2, 3}, nil, []int{1, 2, 3}},
+		{"equal", []int{1, 2, 3}, []int{1, 2, 3}, []int{}},
+		{"hole in the middle", []int{1, 2, 3, 4, 5}, []int{3}, []int{1, 2, 4, 5}},
+		{"overlapping ends", []int{3, 4, 5, 6}, []int{1, 2, 3, 6, 7}, []int{4, 5}},
+		{"several runs", []int{1, 2, 5, 6, 9, 10}, []int{2, 5, 10, 11}, []int{1, 6, 9}},
+		{"disjoint", []int{1, 2}, []int{4, 5}, []int{1, 2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Of(tt.a...).Diff(Of(tt.b...))
+			if got := d.Slice(); !slices.Equal(got, tt.wantDiff) {
+				t.Errorf("got %v, want %v", got, tt.wantDiff)
+			}
+			if d.Len() != len(tt.wantDiff) {
+				t.Errorf("got Len %d, want %d", d.Len(), len(tt.wantDiff))
+			}
+		})
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	s := Of(5, 1, 2, 3, 9, -4)
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[[-4,-4],[1,3],[5,5],[9,9]]" {
+		t.Fatalf("got %s", data)
+	}
+
+	var got Set
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.runs, s.runs) || got.Len() != s.Len() {
+		t.Fatalf("got %v, want %v", got.Slice(), s.Slice())
+	}
+}
+
+func TestUnmarshalJSONMergesAdjacentRuns(t *testing.T) {
+	var s Set
+	if err := json.Unmarshal([]byte("[[1,2],[3,4],[6,6]]"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if want := []run{{1, 4}, {6, 6}}; !slices.Equal(s.runs, want) || s.Len() != 5 {
+		t.Fatalf("got runs %v and Len %d, want %v and 5", s.runs, s.Len(), want)
+	}
+}
+
+func TestUnmarshalJSONRejects(t *testing.T) {
+	for name, data := range map[string]string{
+		"inverted":        "[[3,1]]",
+		"overlapping":     "[[1,3],[2,4]]",
+		"unsorted":        "[[5,6],[1,2]]",
+		"too many ids":    "[[0,1048576]]",
+		"too many in sum": "[[0,1000000],[2000000,2100000]]",
+		"unbounded":       "[[-9223372036854775808,9223372036854775807]]",
+		"not runs":        "[1,2]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var s Set
+			if err := json.Unmarshal([]byte(data), &s); err == nil {
+				t.Errorf("got %d ids, want an error", s.Len())
+			}
+		})
+	}
+
+	var s Set
+	if err := json.Unmarshal([]byte("[[0,1048575]]"), &s); err != nil || s.Len() != maxLen {
+		t.Errorf("got %d ids and %v, want %d ids", s.Len(), err, maxLen)
+	}
+}
+
+const benchIDs = 100_000
+
+// benchOrders are the orders ids are added in: broadcast ids arrive mostly
+// in sequence, but gossip delivers them out of order.
+var benchOrders = []struct {
+	name string
+	ids  func() []int
+}{
+	{"InOrder", func() []int {
+		ids := make([]int, benchIDs)
+		for i := range ids {
+			ids[i] = i
+		}
+		return ids
+	}},
+	{"Window", func() []int {
+		// Ids are shuffled within windows of 1000, as gossip over a few
+		// hops reorders them.
+		rng := rand.New(rand.NewSource(1))
+		ids := make([]int, benchIDs)
+		for i := range ids {
+			ids[i] = i
+		}
+		for lo := 0; lo < len(ids); lo += 1000 {
+			w := ids[lo:min(lo+1000, len(ids))]
+			rng.Shuffle(len(w), func(i, j int) { w[i], w[j] = w[j], w[i] })
+		}
+		return ids
+	}},
+	{"Shuffled", func() []int {
+		return rand.New(rand.NewSource(1)).Perm(benchIDs)
+	}},
+}
+
+func BenchmarkAdd(b *testing.B) {
+	for _, order := range benchOrders {
+		ids := order.ids()
+		b.Run(order.name+"/Set", func(b *testing.B) {
+			for range b.N {
+				s := &Set{}
+				for _, id := range ids {
+					s.Add(id)
+				}
+			}
+		})
+		b.Run(order.name+"/Map", func(b *testing.B) {
+			for range b.N {
+				m := make(map[int]struct{})
+				for _, id := range ids {
+					m[id] = struct{}{}
+				}
+			}
+		})
+		b.Run(order.name+"/SortedSlice", func(b *testing.B) {
+			for range b.N {
+				var s []int
+				for _, id := range ids {
+					if i, ok := slices.BinarySearch(s, id); !ok {
+						s = slices.Insert(s, i, id)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkContains(b *testing.B) {
+	ids := benchOrders[2].ids()
+	set := Of(ids...)
+	m := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		m[id] = struct{}{}
+	}
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+
+	b.Run("Set", func(b *testing.B) {
+		for i := range b.N {
+			set.Contains(ids[i%len(ids)])
+		}
+	})
+	b.Run("Map", func(b *testing.B) {
+		for i := range b.N {
+			_ = m[ids[i%len(ids)]]
+		}
+	})
+	b.Run("SortedSlice", func(b *testing.B) {
+		for i := range b.N {
+			_, _ = slices.BinarySearch(sorted, ids[i%len(ids)])
+		}
+	})
+}
+
+func BenchmarkDiff(b *testing.B) {
+	// The other set misses every 1000th id, as a neighbour that lost a few
+	// messages in a partition would.
+	ids := benchOrders[0].ids()
+	var others []int
+	for _, id := range ids {
+		if id%1000 != 0 {
+			others = append(others, id)
+		}
+	}
+	set, other := Of(ids...), Of(others...)
+	m := make(map[int]struct{}, len(others))
+	for _, id := range others {
+		m[id] = struct{}{}
+	}
+
+	b.Run("Set", func(b *testing.B) {
+		for range b.N {
+			set.Diff(other)
+		}
+	})
+	b.Run("Map", func(b *testing.B) {
+		for range b.N {
+			var d []int
+			for _, id := range ids {
+				if _, ok := m[id]; !ok {
+					d = append(d, id)
+				}
+			}
+		}
+	})
+	b.Run("SortedSlice", func(b *testing.B) {
+		for range b.N {
+			var d []int
+			for _, id := range ids {
+				if _, ok := slices.BinarySearch(others, id); !ok {
+					d = append(d, id)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,182 @@
+// Package kvservice implements the key/value services that Maelstrom provides
+// to nodes (lin-kv, seq-kv and lww-kv), for use with the sim package.
+package kvservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Consistency is the guarantee a Store gives its clients.
+type Consistency int
+
+const (
+	// Linearizable stores always read the latest value.
+	Linearizable Consistency = iota
+
+	// Sequential stores may read stale values, but each client's view of
+	// the store never goes back in time and always includes its own writes.
+	Sequential
+
+	// LastWriteWins stores may read any recent value, with no ordering
+	// guarantee between reads.
+	LastWriteWins
+)
+
+// Options configures a Store.
+type Options struct {
+	Consistency Consistency
+
+	// StaleReads is the probability that a read on a Sequential or
+	// LastWriteWins store is served from an older version than the latest
+	// one allowed. Linearizable stores ignore it.
+	StaleReads float64
+
+	// History is the number of versions kept per key for stale reads.
+	// Defaults to 16.
+	History int
+
+	// Seed seeds the choice of stale versions.
+	Seed int64
+}
+
+// Store is an in-memory key/value service.
+type Store struct {
+	opts Options
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	keys    map[string][]version
+	version int
+	floors  map[string]int
+}
+
+type version struct {
+	n     int
+	value any
+}
+
+// New returns an empty store.
+func New(opts Options) *Store {
+	if opts.History == 0 {
+		opts.History = 16
+	}
+	return &Store{
+		opts:   opts,
+		rand:   rand.New(rand.NewSource(opts.Seed)),
+		keys:   make(map[string][]version),
+		floors: make(map[string]int),
+	}
+}
+
+// NewLinKV returns a linearizable store, like Maelstrom's lin-kv.
+func NewLinKV() *Store {
+	return New(Options{Consistency: Linearizable})
+}
+
+// NewSeqKV returns a sequentially consistent store, like Maelstrom's seq-kv,
+// that serves stale reads with the given probability.
+func NewSeqKV(staleReads float64, seed int64) *Store {
+	return New(Options{Consistency: Sequential, StaleReads: staleReads, Seed: seed})
+}
+
+// NewLWWKV returns a last-write-wins store, like Maelstrom's lww-kv, that
+// serves stale reads with the given probability.
+func NewLWWKV(staleReads float64, seed int64) *Store {
+	return New(Options{Consistency: LastWriteWins, StaleReads: staleReads, Seed: seed})
+}
+
+type request struct {
+	Type              string `json:"type"`
+	Key               any    `json:"key"`
+	Value             any    `json:"value"`
+	From              any    `json:"from"`
+	To                any    `json:"to"`
+	CreateIfNotExists bool   `json:"create_if_not_exists"`
+}
+
+// Handle serves a read, write or cas request from src.
+func (s *Store) Handle(src string, body json.RawMessage) any {
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+	}
+	key := fmt.Sprint(req.Key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Type {
+	case "read":
+		value, ok := s.read(src, key)
+		if !ok {
+			return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+		return map[string]any{"type": "read_ok", "value": value}
+
+	case "write":
+		s.write(src, key, req.Value)
+		return map[string]any{"type": "write_ok"}
+
+	case "cas":
+		versions, ok := s.keys[key]
+		if !ok {
+			if !req.CreateIfNotExists {
+				return maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+			}
+		} else if current := versions[len(versions)-1].value; !reflect.DeepEqual(current, req.From) {
+			return maelstrom.NewRPCError(maelstrom.PreconditionFailed,
+				fmt.Sprintf("current value %v is not %v", current, req.From))
+		}
+		s.write(src, key, req.To)
+		return map[string]any{"type": "cas_ok"}
+
+	default:
+		return maelstrom.NewRPCError(maelstrom.NotSupported, fmt.Sprintf("unsupported request type %q", req.Type))
+	}
+}
+
+func (s *Store) read(src, key string) (any, bool) {
+	versions := s.keys[key]
+	latest := s.version
+
+	snapshot := latest
+	if s.opts.Consistency != Linearizable && s.rand.Float64() < s.opts.StaleReads {
+		lo := 0
+		if len(versions) == s.opts.History {
+			// Versions older than the retained history cannot be served.
+			lo = versions[0].n
+		}
+		if s.opts.Consistency == Sequential {
+			lo = max(lo, s.floors[src])
+		}
+		if lo < latest {
+			snapshot = lo + s.rand.Intn(latest-lo+1)
+		}
+	}
+	if s.opts.Consistency == Sequential {
+		s.floors[src] = max(s.floors[src], snapshot)
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].n <= snapshot {
+			return versions[i].value, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Store) write(src, key string, value any) {
+	s.version++
+	versions := append(s.keys[key], version{s.version, value})
+	if len(versions) > s.opts.History {
+		versions = versions[len(versions)-s.opts.History:]
+	}
+	s.keys[key] = versions
+	s.floors[src] = s.version
+}
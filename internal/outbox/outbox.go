@@ -0,0 +1,334 @@
+// Package outbox delivers messages to other nodes reliably: every message is
+// retried until the destination acknowledges it or the outbox is closed.
+package outbox
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+)
+
+// Options configure an Outbox. Zero values select the defaults.
+type Options struct {
+	// Timeout bounds each delivery attempt. Defaults to 1 second.
+	Timeout time.Duration
+
+	// RTT, if set, replaces Timeout with a timeout derived from the round
+	// trips measured to each destination, and measures every attempt.
+	RTT *rtt.Tracker
+
+	// MinBackoff and MaxBackoff bound the delay before retrying a
+	// destination after a failed attempt. The delay doubles with every
+	// consecutive failure and is jittered by up to half. They default to
+	// 100 milliseconds and 1 second.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxAttempts is the number of attempts after which a message is
+	// dropped. Zero means retrying until the outbox is closed, which suits
+	// callers with no other way of repairing a lost message.
+	MaxAttempts int
+
+	// MaxInFlight is the number of messages that may await acknowledgement
+	// from one destination at a time. Defaults to 32.
+	MaxInFlight int
+
+	// Seed is mixed with the node id and the destination to seed the
+	// jitter.
+	Seed int64
+
+	// Reachable, if set, is consulted before every attempt. While it
+	// reports a destination as unreachable, attempts to it are postponed
+	// with the usual backoff and do not count towards MaxAttempts.
+	Reachable func(dest string) bool
+}
+
+// Outbox queues messages per destination and delivers them with RPCs.
+type Outbox struct {
+	n    *maelstrom.Node
+	clk  clock.Clock
+	opts Options
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	drained *sync.Cond
+	queues  map[string]*queue
+	pending int
+	closed  bool
+}
+
+type queue struct {
+	dest     string
+	msgs     []*envelope
+	ready    *sync.Cond
+	failures int
+
+	// rand jitters the backoff. Every destination has its own, so that
+	// the delays drawn for one do not depend on how the workers of the
+	// others happened to be scheduled.
+	rand *rand.Rand
+}
+
+type envelope struct {
+	body  proto.Body
+	acked func()
+}
+
+// New returns an Outbox that sends from n.
+func New(n *maelstrom.Node, clk clock.Clock, opts Options) *Outbox {
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = time.Second
+	}
+	if opts.MaxInFlight == 0 {
+		opts.MaxInFlight = 32
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	o := &Outbox{
+		n:      n,
+		clk:    clk,
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+		queues: make(map[string]*queue),
+	}
+	o.drained = sync.NewCond(&o.mu)
+	return o
+}
+
+// Send queues body for delivery to dest.
+func (o *Outbox) Send(dest string, body proto.Body) {
+	o.SendFunc(dest, body, nil)
+}
+
+// SendFunc queues body for delivery to dest and calls acked once dest has
+// acknowledged it. acked is not called for messages that are dropped because
+// dest rejected them, they ran out of attempts or the outbox was closed.
+func (o *Outbox) SendFunc(dest string, body proto.Body, acked func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+
+	q, ok := o.queues[dest]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(o.n.ID() + " " + dest))
+		q = &queue{dest: dest, ready: sync.NewCond(&o.mu), rand: rand.New(rand.NewSource(int64(h.Sum64()) ^ o.opts.Seed))}
+		o.queues[dest] = q
+		o.wg.Add(o.opts.MaxInFlight)
+		for range o.opts.MaxInFlight {
+			go o.worker(q)
+		}
+	}
+	q.msgs = append(q.msgs, &envelope{body, acked})
+	o.pending++
+	q.ready.Signal()
+}
+
+// Pending returns the number of messages that have not been acknowledged
+// yet.
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.pending
+}
+
+// Flush waits until every queued message has been acknowledged or dropped,
+// or ctx is done.
+func (o *Outbox) Flush(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		o.mu.Lock()
+		o.drained.Broadcast()
+		o.mu.Unlock()
+	})
+	defer stop()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for o.pending > 0 && !o.closed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		o.drained.Wait()
+	}
+	return nil
+}
+
+// Close stops delivery and waits for the in-flight attempts to finish. It
+// then sends every message that was not acknowledged once more, without
+// waiting for acknowledgements, and drops them. Close is meant to be called
+// once the node has stopped reading its input, when acknowledgements can no
+// longer arrive. Sending to a closed outbox does nothing.
+func (o *Outbox) Close() {
+	o.mu.Lock()
+	o.closed = true
+	o.drained.Broadcast()
+	for _, q := range o.queues {
+		q.ready.Broadcast()
+	}
+	o.mu.Unlock()
+
+	o.cancel()
+	o.wg.Wait()
+
+	// The workers have put back the messages they held, so every message
+	// left is in a queue. Destinations are walked in order to keep the
+	// sends the same on every run.
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	dests := make([]string, 0, len(o.queues))
+	for dest := range o.queues {
+		dests = append(dests, dest)
+	}
+	slices.Sort(dests)
+	for _, dest := range dests {
+		for _, e := range o.queues[dest].msgs {
+			proto.Notify(o.n, dest, e.body)
+		}
+		o.queues[dest].msgs = nil
+	}
+	if o.pending > 0 {
+		log.Printf("outbox closed with %d unacknowledged messages", o.pending)
+	}
+}
+
+// worker delivers the messages of q one at a time, retrying each until it
+// is done with or out of attempts.
+func (o *Outbox) worker(q *queue) {
+	defer o.wg.Done()
+	for {
+		e, ok := o.next(q)
+		if !ok {
+			return
+		}
+		acked := false
+		for attempts := 0; ; {
+			if o.opts.Reachable == nil || o.opts.Reachable(q.dest) {
+				var done bool
+				if done, acked = o.deliver(q.dest, e); done {
+					break
+				}
+				attempts++
+				if attempts == o.opts.MaxAttempts {
+					break
+				}
+			}
+			if !o.sleep(o.backoff(q)) {
+				o.putBack(q, e)
+				return
+			}
+		}
+		o.done(q, acked)
+	}
+}
+
+// next takes the next message for q, waiting until there is one. It returns
+// false once the outbox is closed.
+func (o *Outbox) next(q *queue) (*envelope, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(q.msgs) == 0 && !o.closed {
+		q.ready.Wait()
+	}
+	if o.closed {
+		return nil, false
+	}
+	e := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	return e, true
+}
+
+// putBack returns e, which is still unacknowledged, to the front of q for
+// Close to send.
+func (o *Outbox) putBack(q *queue, e *envelope) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q.msgs = append([]*envelope{e}, q.msgs...)
+}
+
+// deliver makes one attempt at delivering e. It reports whether e is done
+// with, either because dest acknowledged it or because dest rejected it in a
+// way that retrying cannot fix, and whether dest acknowledged it.
+func (o *Outbox) deliver(dest string, e *envelope) (done, acked bool) {
+	call := func(ctx context.Context) error {
+		_, err := proto.Call(ctx, o.n, dest, e.body)
+		return err
+	}
+	var err error
+	if o.opts.RTT != nil {
+		err = o.opts.RTT.Call(o.ctx, dest, call)
+	} else {
+		ctx, cancel := o.clk.WithTimeout(o.ctx, o.opts.Timeout)
+		err = call(ctx)
+		cancel()
+	}
+	switch maelstrom.ErrorCode(err) {
+	case maelstrom.MalformedRequest, maelstrom.NotSupported:
+		log.Printf("dropping %s to %s: %s", e.body.Type(), dest, err)
+		return true, false
+	}
+	if err != nil {
+		return false, false
+	}
+	if e.acked != nil {
+		e.acked()
+	}
+	return true, true
+}
+
+// done records that a message of q is done with. Only an acknowledged
+// message shows that the destination can be reached again and resets the
+// backoff.
+func (o *Outbox) done(q *queue, acked bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if acked {
+		q.failures = 0
+	}
+	o.pending--
+	o.drained.Broadcast()
+}
+
+// backoff records a failed attempt to reach q's destination and returns how
+// long to wait before the next one.
+func (o *Outbox) backoff(q *queue) time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q.failures++
+
+	d := o.opts.MinBackoff
+	for i := 1; i < q.failures && d < o.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, o.opts.MaxBackoff)
+	return d/2 + time.Duration(q.rand.Int63n(int64(d/2)+1))
+}
+
+func (o *Outbox) sleep(d time.Duration) bool {
+	select {
+	case <-o.ctx.Done():
+		return false
+	case <-o.clk.After(d):
+		return true
+	}
+}
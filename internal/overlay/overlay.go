@@ -0,0 +1,314 @@
+// Package overlay agrees on a broadcast tree that follows the measured
+// latencies between nodes. Every node probes the others to measure round trip
+// times, publishes them as a versioned row, and gossips the rows of all nodes
+// to random peers. Once a node holds a row from every node, it builds the
+// tree with topology.Latency, so nodes holding the same rows build the same
+// tree. A node publishes a new row when a round trip time drifts by more than
+// a factor of Degrade from the published one or a peer stops answering, and
+// every node then rebuilds the tree around the degraded link.
+package overlay
+
+import (
+	"context"
+	"hash/fnv"
+	"maps"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/rtt"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
+)
+
+// row is what a node has measured. RTTs are in microseconds, and peers that
+// did not answer their last maxFailures probes are missing.
+type row struct {
+	Version int              `json:"version"`
+	RTTs    map[string]int64 `json:"rtts"`
+}
+
+type probe struct{}
+
+type probeOk struct{}
+
+// rows gossips the rows the sender holds.
+type rows struct {
+	Rows map[string]row `json:"rows" required:"true"`
+}
+
+func (probe) Type() string   { return "overlay_probe" }
+func (probeOk) Type() string { return "overlay_probe_ok" }
+func (rows) Type() string    { return "overlay_rows" }
+
+// unmeasured is the round trip time assumed for links that neither end has
+// measured, so that they are only used when there is no other way.
+const unmeasured = time.Minute
+
+// maxFailures is the number of probes in a row a peer must fail before the
+// link to it is dropped from the row, since single probes time out whenever
+// a round trip is far above the average.
+const maxFailures = 3
+
+// warmup is the number of intervals at startup in which every peer is
+// probed, so that the first tree is built from more than one sample per
+// link.
+const warmup = 3
+
+// Options configure an Overlay. Zero values select the defaults.
+type Options struct {
+	// K is the maximum number of children of a node. Defaults to 4.
+	K int
+
+	// Interval is the time between two rounds, in which a node probes one
+	// peer and gossips its rows to Fanout peers. Defaults to 1 second.
+	Interval time.Duration
+
+	// Fanout is the number of peers the rows are gossiped to every round.
+	// Defaults to 2.
+	Fanout int
+
+	// Degrade is the factor by which a round trip time must change before
+	// a new row is published. Defaults to 2.
+	Degrade float64
+
+	// Seed is mixed with the node id to seed the probe and gossip order.
+	Seed int64
+}
+
+// Overlay is a node's view of the measured latencies and the tree built from
+// them.
+type Overlay struct {
+	n    *maelstrom.Node
+	clk  clock.Clock
+	opts Options
+	rtts *rtt.Tracker
+
+	ticker clock.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	rows     map[string]row
+	failures map[string]int
+	tree     map[string][]string
+	built    bool
+	rounds   int
+	order    []string
+	rand     *rand.Rand
+}
+
+// Start registers the overlay handlers on n and starts probing. It must be
+// called before n.Run.
+func Start(n *maelstrom.Node, clk clock.Clock, opts Options) *Overlay {
+	if opts.K == 0 {
+		opts.K = 4
+	}
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Fanout == 0 {
+		opts.Fanout = 2
+	}
+	if opts.Degrade == 0 {
+		opts.Degrade = 2
+	}
+	o := &Overlay{
+		n:        n,
+		clk:      clk,
+		opts:     opts,
+		rtts:     rtt.New(clk, rtt.Options{}),
+		ticker:   clk.NewTicker(opts.Interval),
+		done:     make(chan struct{}),
+		rows:     make(map[string]row),
+		failures: make(map[string]int),
+	}
+
+	proto.Handle(n, func(msg maelstrom.Message, _ probe) error {
+		return proto.Reply(n, msg, probeOk{})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req rows) error {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for node, r := range req.Rows {
+			if node != n.ID() && r.Version > o.rows[node].Version {
+				o.rows[node] = r
+				o.built = false
+			}
+		}
+		return nil
+	})
+
+	o.wg.Add(1)
+	go o.run()
+	return o
+}
+
+// Stop stops probing and gossiping and waits for the current round.
+func (o *Overlay) Stop() {
+	o.ticker.Stop()
+	close(o.done)
+	o.wg.Wait()
+}
+
+// Tree returns the neighbours of every node in the latency tree, and false
+// until the node holds a row from every node.
+func (o *Overlay) Tree() (map[string][]string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.built {
+		return o.tree, true
+	}
+	// Before init there are no nodes, which every row would trivially
+	// cover.
+	if !proto.Initialized(o.n) {
+		return nil, false
+	}
+	nodes := o.n.NodeIDs()
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	for _, node := range nodes {
+		if _, ok := o.rows[node]; !ok {
+			return nil, false
+		}
+	}
+	o.tree = topology.Latency(nodes, o.weight, o.opts.K)
+	o.built = true
+	return o.tree, true
+}
+
+// weight returns the round trip time between a and b: the larger of the
+// ones they measured, or unmeasured if neither did. o.mu must be held.
+func (o *Overlay) weight(a, b string) time.Duration {
+	d, ok := time.Duration(0), false
+	if us, found := o.rows[a].RTTs[b]; found {
+		d, ok = max(d, time.Duration(us)*time.Microsecond), true
+	}
+	if us, found := o.rows[b].RTTs[a]; found {
+		d, ok = max(d, time.Duration(us)*time.Microsecond), true
+	}
+	if !ok {
+		return unmeasured
+	}
+	return d
+}
+
+func (o *Overlay) run() {
+	defer o.wg.Done()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-o.ticker.C():
+		}
+		if !proto.Initialized(o.n) {
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, peer := range o.targets() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				o.probe(peer)
+			}()
+		}
+		wg.Wait()
+		o.gossip()
+	}
+}
+
+// targets returns the peers to probe this round: all of them during the
+// warmup, and then the next one in a shuffled round-robin order.
+func (o *Overlay) targets() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.rand == nil {
+		h := fnv.New64a()
+		h.Write([]byte(o.n.ID()))
+		o.rand = rand.New(rand.NewSource(int64(h.Sum64()) ^ o.opts.Seed))
+		// An empty row tells the others the node is there before it has
+		// measured anything.
+		o.rows[o.n.ID()] = row{Version: 1, RTTs: map[string]int64{}}
+	}
+	o.rounds++
+	if o.rounds <= warmup {
+		return o.peers()
+	}
+	if len(o.order) == 0 {
+		o.order = o.peers()
+		o.rand.Shuffle(len(o.order), func(i, j int) { o.order[i], o.order[j] = o.order[j], o.order[i] })
+	}
+	if len(o.order) == 0 {
+		return nil
+	}
+	peer := o.order[0]
+	o.order = o.order[1:]
+	return []string{peer}
+}
+
+// probe measures the round trip time to peer and publishes a new row if it
+// changed by more than Degrade or peer failed maxFailures probes in a row.
+func (o *Overlay) probe(peer string) {
+	_, err := rtt.RPC[probeOk](context.Background(), o.rtts, o.n, peer, probe{})
+	srtt, measured := o.rtts.SRTT(peer)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err != nil {
+		o.failures[peer]++
+	} else {
+		o.failures[peer] = 0
+	}
+	lost := o.failures[peer] >= maxFailures || !measured
+	own := o.rows[o.n.ID()]
+	published, ok := own.RTTs[peer]
+	switch {
+	case err != nil && !lost:
+		return
+	case lost:
+		if !ok {
+			return
+		}
+	case ok:
+		ratio := float64(srtt.Microseconds()) / float64(max(published, 1))
+		if ratio <= o.opts.Degrade && ratio >= 1/o.opts.Degrade {
+			return
+		}
+	}
+
+	next := row{Version: own.Version + 1, RTTs: maps.Clone(own.RTTs)}
+	if lost {
+		delete(next.RTTs, peer)
+	} else {
+		next.RTTs[peer] = srtt.Microseconds()
+	}
+	o.rows[o.n.ID()] = next
+	o.built = false
+}
+
+// gossip sends the rows the node holds to Fanout random peers.
+func (o *Overlay) gossip() {
+	o.mu.Lock()
+	peers := o.peers()
+	o.rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	body := rows{Rows: maps.Clone(o.rows)}
+	o.mu.Unlock()
+
+	for _, peer := range peers[:min(len(peers), o.opts.Fanout)] {
+		proto.Notify(o.n, peer, body)
+	}
+}
+
+func (o *Overlay) peers() []string {
+	var peers []string
+	for _, node := range o.n.NodeIDs() {
+		if node != o.n.ID() {
+			peers = append(peers, node)
+		}
+	}
+	return peers
+}
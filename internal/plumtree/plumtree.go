@@ -0,0 +1,272 @@
+// Package plumtree implements Plumtree epidemic broadcast trees: messages
+// are pushed eagerly along a spanning tree of the overlay and announced
+// lazily to the remaining peers. A node that receives a message twice prunes
+// the second link out of the tree, and a node that hears of a message it
+// never received grafts the announcing link back in, so the tree repairs
+// itself when a link fails.
+package plumtree
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// gossip pushes a message along the tree.
+type gossip struct {
+	ID int `json:"id"`
+}
+
+// ihave announces messages the sender has received.
+type ihave struct {
+	IDs []int `json:"ids" required:"true"`
+}
+
+// graft asks the receiver to add the sender to its tree and to push the
+// listed messages again.
+type graft struct {
+	IDs []int `json:"ids"`
+}
+
+// prune asks the receiver to stop pushing messages to the sender.
+type prune struct{}
+
+func (gossip) Type() string { return "plumtree_gossip" }
+func (ihave) Type() string  { return "plumtree_ihave" }
+func (graft) Type() string  { return "plumtree_graft" }
+func (prune) Type() string  { return "plumtree_prune" }
+
+// Options configure a Tree. Zero values select the defaults.
+type Options struct {
+	// LazyInterval is the time between two batches of announcements.
+	// Defaults to 200 milliseconds.
+	LazyInterval time.Duration
+
+	// GraftTimeout is how long a node waits for an announced message before
+	// grafting the link it was announced on, and then before trying the
+	// next one. Defaults to 1 second.
+	GraftTimeout time.Duration
+}
+
+// Tree is the Plumtree state of a node. It holds the set of messages the
+// node has received.
+type Tree struct {
+	n    *maelstrom.Node
+	clk  clock.Clock
+	opts Options
+
+	ticker clock.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	received map[int]bool
+	ids      []int
+	eager    map[string]bool
+	lazy     map[string]bool
+	announce map[string][]int
+	missing  map[int]*missing
+}
+
+// missing tracks a message that was announced but not received.
+type missing struct {
+	sources  []string
+	next     int
+	deadline time.Time
+}
+
+// New registers the Plumtree handlers on n and starts announcing. It must be
+// called before n.Run.
+func New(n *maelstrom.Node, clk clock.Clock, opts Options) *Tree {
+	if opts.LazyInterval == 0 {
+		opts.LazyInterval = 200 * time.Millisecond
+	}
+	if opts.GraftTimeout == 0 {
+		opts.GraftTimeout = time.Second
+	}
+	t := &Tree{
+		n:        n,
+		clk:      clk,
+		opts:     opts,
+		ticker:   clk.NewTicker(opts.LazyInterval),
+		done:     make(chan struct{}),
+		received: make(map[int]bool),
+		eager:    make(map[string]bool),
+		lazy:     make(map[string]bool),
+		announce: make(map[string][]int),
+		missing:  make(map[int]*missing),
+	}
+
+	proto.Handle(n, func(msg maelstrom.Message, req gossip) error {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.received[req.ID] {
+			t.setLazy(msg.Src)
+			return proto.Notify(n, msg.Src, prune{})
+		}
+		t.setEager(msg.Src)
+		t.deliver(req.ID, msg.Src)
+		return nil
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req ihave) error {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, id := range req.IDs {
+			if t.received[id] {
+				continue
+			}
+			m, ok := t.missing[id]
+			if !ok {
+				m = &missing{deadline: t.clk.Now().Add(t.opts.GraftTimeout)}
+				t.missing[id] = m
+			}
+			if !slices.Contains(m.sources, msg.Src) {
+				m.sources = append(m.sources, msg.Src)
+			}
+		}
+		return nil
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req graft) error {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.setEager(msg.Src)
+		for _, id := range req.IDs {
+			if t.received[id] {
+				proto.Notify(n, msg.Src, gossip{id})
+			}
+		}
+		return nil
+	})
+	proto.Handle(n, func(msg maelstrom.Message, _ prune) error {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.setLazy(msg.Src)
+		return nil
+	})
+
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+// SetPeers replaces the peers of the node. All of them start in the tree.
+func (t *Tree) SetPeers(peers []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.eager)
+	clear(t.lazy)
+	for _, p := range peers {
+		t.eager[p] = true
+	}
+}
+
+// Broadcast delivers id locally and pushes it to the tree. It reports
+// whether id is new.
+func (t *Tree) Broadcast(id int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.received[id] {
+		return false
+	}
+	t.deliver(id, "")
+	return true
+}
+
+// Add delivers ids received by other means, such as anti-entropy, and
+// pushes the new ones like broadcasts.
+func (t *Tree) Add(ids []int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range ids {
+		if !t.received[id] {
+			t.deliver(id, "")
+		}
+	}
+}
+
+// IDs returns a snapshot of the received messages.
+func (t *Tree) IDs() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.ids)
+}
+
+// Stop stops announcing and grafting.
+func (t *Tree) Stop() {
+	t.ticker.Stop()
+	close(t.done)
+	t.wg.Wait()
+}
+
+// deliver records id and forwards it to every peer but src: eagerly to the
+// tree and lazily to the rest.
+func (t *Tree) deliver(id int, src string) {
+	t.received[id] = true
+	t.ids = append(t.ids, id)
+	delete(t.missing, id)
+	for p := range t.eager {
+		if p != src {
+			proto.Notify(t.n, p, gossip{id})
+		}
+	}
+	for p := range t.lazy {
+		if p != src {
+			t.announce[p] = append(t.announce[p], id)
+		}
+	}
+}
+
+func (t *Tree) setEager(p string) {
+	delete(t.lazy, p)
+	t.eager[p] = true
+}
+
+func (t *Tree) setLazy(p string) {
+	delete(t.eager, p)
+	t.lazy[p] = true
+}
+
+func (t *Tree) run() {
+	defer t.wg.Done()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-t.ticker.C():
+		}
+		t.tick()
+	}
+}
+
+// tick sends the pending announcements and grafts the links of messages
+// that were announced but did not arrive in time, trying the next announcing
+// peer every GraftTimeout.
+func (t *Tree) tick() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for p, ids := range t.announce {
+		proto.Notify(t.n, p, ihave{ids})
+		delete(t.announce, p)
+	}
+
+	now := t.clk.Now()
+	grafts := make(map[string][]int)
+	for id, m := range t.missing {
+		if now.Before(m.deadline) {
+			continue
+		}
+		p := m.sources[m.next%len(m.sources)]
+		m.next++
+		m.deadline = now.Add(t.opts.GraftTimeout)
+		grafts[p] = append(grafts[p], id)
+	}
+	for p, ids := range grafts {
+		t.setEager(p)
+		proto.Notify(t.n, p, graft{ids})
+	}
+}
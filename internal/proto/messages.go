@@ -0,0 +1,204 @@
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Echo is the echo workload request. The echoed value is passed through
+// unchanged.
+type Echo struct {
+	Echo json.RawMessage `json:"echo" required:"true"`
+}
+
+type EchoOk struct {
+	Echo json.RawMessage `json:"echo"`
+}
+
+func (Echo) Type() string   { return "echo" }
+func (EchoOk) Type() string { return "echo_ok" }
+
+// Generate is the unique-ids workload request.
+type Generate struct{}
+
+type GenerateOk struct {
+	ID string `json:"id"`
+}
+
+func (Generate) Type() string   { return "generate" }
+func (GenerateOk) Type() string { return "generate_ok" }
+
+// Broadcast is the broadcast workload request.
+type Broadcast struct {
+	Message int `json:"message" required:"true"`
+}
+
+type BroadcastOk struct{}
+
+func (Broadcast) Type() string   { return "broadcast" }
+func (BroadcastOk) Type() string { return "broadcast_ok" }
+
+// Read is the read request of both the broadcast and the counter workloads.
+type Read struct{}
+
+// ReadMessagesOk is the broadcast workload response to Read.
+type ReadMessagesOk struct {
+	Messages []int `json:"messages"`
+}
+
+// ReadValueOk is the counter workload response to Read.
+type ReadValueOk struct {
+	Value int `json:"value"`
+}
+
+func (Read) Type() string           { return "read" }
+func (ReadMessagesOk) Type() string { return "read_ok" }
+func (ReadValueOk) Type() string    { return "read_ok" }
+
+// Topology tells a broadcast node the neighbours of every node.
+type Topology struct {
+	Topology map[string][]string `json:"topology" required:"true"`
+}
+
+type TopologyOk struct{}
+
+func (Topology) Type() string   { return "topology" }
+func (TopologyOk) Type() string { return "topology_ok" }
+
+// Add is the counter workload request.
+type Add struct {
+	Delta int `json:"delta" required:"true"`
+}
+
+type AddOk struct{}
+
+func (Add) Type() string   { return "add" }
+func (AddOk) Type() string { return "add_ok" }
+
+// LogEntry is an [offset, msg] pair of a kafka log.
+type LogEntry [2]int
+
+func (e LogEntry) Offset() int { return e[0] }
+func (e LogEntry) Msg() int    { return e[1] }
+
+// Send appends Msg to the kafka log for Key.
+type Send struct {
+	Key string `json:"key" required:"true"`
+	Msg int    `json:"msg" required:"true"`
+}
+
+type SendOk struct {
+	Offset int `json:"offset"`
+}
+
+func (Send) Type() string   { return "send" }
+func (SendOk) Type() string { return "send_ok" }
+
+// Poll requests the entries of each log starting at the given offset.
+type Poll struct {
+	Offsets map[string]int `json:"offsets" required:"true"`
+}
+
+type PollOk struct {
+	Msgs map[string][]LogEntry `json:"msgs"`
+}
+
+func (Poll) Type() string   { return "poll" }
+func (PollOk) Type() string { return "poll_ok" }
+
+func (p Poll) Validate() error { return validateOffsets(p.Offsets) }
+
+// CommitOffsets marks the entries of each log up to the given offset as
+// processed.
+type CommitOffsets struct {
+	Offsets map[string]int `json:"offsets" required:"true"`
+}
+
+type CommitOffsetsOk struct{}
+
+func (CommitOffsets) Type() string   { return "commit_offsets" }
+func (CommitOffsetsOk) Type() string { return "commit_offsets_ok" }
+
+func (c CommitOffsets) Validate() error { return validateOffsets(c.Offsets) }
+
+// ListCommittedOffsets requests the last offset committed for each of Keys.
+// Keys with no committed offset are left out of the reply.
+type ListCommittedOffsets struct {
+	Keys []string `json:"keys" required:"true"`
+}
+
+type ListCommittedOffsetsOk struct {
+	Offsets map[string]int `json:"offsets"`
+}
+
+func (ListCommittedOffsets) Type() string   { return "list_committed_offsets" }
+func (ListCommittedOffsetsOk) Type() string { return "list_committed_offsets_ok" }
+
+func validateOffsets(offsets map[string]int) error {
+	for key, offset := range offsets {
+		if offset < 0 {
+			return fmt.Errorf("negative offset %d for key %s", offset, key)
+		}
+	}
+	return nil
+}
+
+// Txn is the txn-rw-register workload request.
+type Txn struct {
+	Txn []Op `json:"txn" required:"true"`
+}
+
+type TxnOk struct {
+	Txn []Op `json:"txn"`
+}
+
+func (Txn) Type() string   { return "txn" }
+func (TxnOk) Type() string { return "txn_ok" }
+
+// Op is a micro-operation of a transaction, encoded as [name, key, value].
+// Name is "r" or "w"; Value is nil for reads of missing keys.
+type Op struct {
+	Name  string
+	Key   int
+	Value *int
+}
+
+func (o Op) IsRead() bool { return o.Name == "r" }
+
+func (o Op) String() string {
+	if o.Value == nil {
+		return fmt.Sprintf("%s(%d, nil)", o.Name, o.Key)
+	}
+	return fmt.Sprintf("%s(%d, %d)", o.Name, o.Key, *o.Value)
+}
+
+func (o Op) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{o.Name, o.Key, o.Value})
+}
+
+func (o *Op) UnmarshalJSON(data []byte) error {
+	var op []json.RawMessage
+	if err := json.Unmarshal(data, &op); err != nil {
+		return err
+	}
+	if len(op) != 3 {
+		return fmt.Errorf("operation %s does not have 3 elements", data)
+	}
+	if err := json.Unmarshal(op[0], &o.Name); err != nil {
+		return fmt.Errorf("operation name: %w", err)
+	}
+	if o.Name != "r" && o.Name != "w" {
+		return fmt.Errorf("unknown operation %q", o.Name)
+	}
+	if err := json.Unmarshal(op[1], &o.Key); err != nil {
+		return fmt.Errorf("operation key: %w", err)
+	}
+	o.Value = nil
+	if err := json.Unmarshal(op[2], &o.Value); err != nil {
+		return fmt.Errorf("operation value: %w", err)
+	}
+	if o.Name == "w" && o.Value == nil {
+		return fmt.Errorf("write to key %d has no value", o.Key)
+	}
+	return nil
+}
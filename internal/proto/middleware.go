@@ -0,0 +1,112 @@
+package proto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"runtime/debug"
+	"strings"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Validator is implemented by bodies that check their fields after
+// decoding. A non-nil error is returned to the sender as MalformedRequest.
+type Validator interface {
+	Validate() error
+}
+
+// Malformed returns a MalformedRequest error.
+func Malformed(format string, args ...any) error {
+	return maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf(format, args...))
+}
+
+// Unavailable returns a TemporarilyUnavailable error. The sender can assume
+// the request had no effect and retry it.
+func Unavailable(format string, args ...any) error {
+	return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf(format, args...))
+}
+
+// NotSupported returns a NotSupported error.
+func NotSupported(format string, args ...any) error {
+	return maelstrom.NewRPCError(maelstrom.NotSupported, fmt.Sprintf(format, args...))
+}
+
+// guard wraps a handler so that it cannot take the node down:
+//   - requests arriving before the node is initialized are answered with
+//     TemporarilyUnavailable;
+//   - panics are recovered and answered with Crash;
+//   - any other error that is not an *maelstrom.RPCError is answered with
+//     Crash, which tells the sender the request may still have taken effect.
+//
+// Context errors are answered with Crash rather than Timeout: Timeout is
+// code 0, which the Maelstrom library omits when it encodes the error, so
+// the sender would decode the reply as a success.
+//
+// Errors for requests sent with Notify are logged instead, because their
+// sender does not expect a response.
+func guard(n *maelstrom.Node, h maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+	return func(msg maelstrom.Message) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("panic handling %s: %v\n%s", msg.Body, r, debug.Stack())
+				err = maelstrom.NewRPCError(maelstrom.Crash, fmt.Sprintf("panic: %v", r))
+			}
+			if err == nil {
+				return
+			}
+			err = toRPCError(err)
+			var body maelstrom.MessageBody
+			if jsonErr := json.Unmarshal(msg.Body, &body); jsonErr != nil || body.MsgID == 0 {
+				log.Printf("error handling %s: %s", msg.Body, err)
+				err = nil
+			}
+		}()
+
+		if !Initialized(n) {
+			return Unavailable("node is not initialized")
+		}
+		return h(msg)
+	}
+}
+
+func toRPCError(err error) error {
+	var rpcErr *maelstrom.RPCError
+	switch {
+	case errors.As(err, &rpcErr) && rpcErr.Code != maelstrom.Timeout:
+		return rpcErr
+	default:
+		return maelstrom.NewRPCError(maelstrom.Crash, err.Error())
+	}
+}
+
+// validate checks that the fields of body tagged `required:"true"` are
+// present in raw, then calls body's Validate method if it has one.
+func validate(raw json.RawMessage, body any) error {
+	t := reflect.TypeOf(body)
+	if t.Kind() == reflect.Struct {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if f.Tag.Get("required") != "true" {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" {
+				name = f.Name
+			}
+			if v, ok := fields[name]; !ok || string(v) == "null" {
+				return fmt.Errorf("missing field %q", name)
+			}
+		}
+	}
+	if v, ok := body.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
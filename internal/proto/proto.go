@@ -0,0 +1,132 @@
+// Package proto defines typed message bodies for the Maelstrom workloads and
+// helpers to handle, send and reply with them.
+package proto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Body is a message body. Type returns the value of its "type" field, which
+// is added when the body is marshaled by Reply, Notify or RPC.
+type Body interface {
+	Type() string
+}
+
+// Handle registers h for messages of T's type. Bodies that cannot be decoded
+// into T are answered with a MalformedRequest error instead of reaching h,
+// and h is wrapped so that errors and panics are answered with the matching
+// Maelstrom error code instead of stopping the node.
+func Handle[T Body](n *maelstrom.Node, h func(msg maelstrom.Message, req T) error) {
+	var zero T
+	register(n, zero.Type())
+	n.Handle(zero.Type(), guard(n, func(msg maelstrom.Message) error {
+		req, err := Decode[T](msg)
+		if err != nil {
+			return err
+		}
+		return h(msg, req)
+	}))
+}
+
+// Decode unmarshals the body of msg into T and validates it. It returns a
+// MalformedRequest error if the body does not match.
+func Decode[T Body](msg maelstrom.Message) (T, error) {
+	var body T
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return body, Malformed("malformed %s body: %s", msg.Type(), err)
+	}
+	if err := validate(msg.Body, body); err != nil {
+		return body, Malformed("malformed %s body: %s", msg.Type(), err)
+	}
+	return body, nil
+}
+
+// Reply sends body in response to req. Requests without a msg_id were sent
+// with Notify and expect no response, so nothing is sent for them.
+func Reply(n *maelstrom.Node, req maelstrom.Message, body Body) error {
+	var reqBody maelstrom.MessageBody
+	if err := json.Unmarshal(req.Body, &reqBody); err != nil {
+		return err
+	}
+	if reqBody.MsgID == 0 {
+		return nil
+	}
+	return n.Reply(req, typed{body})
+}
+
+// Notify sends body to dest without expecting a response.
+func Notify(n *maelstrom.Node, dest string, body Body) error {
+	return n.Send(dest, typed{body})
+}
+
+// Call sends req to dest and waits for the response. Error responses are
+// returned as *maelstrom.RPCError.
+//
+// Unlike n.SyncRPC, a response that arrives after ctx is done is discarded
+// instead of blocking its callback forever, which would keep n.Run from
+// returning.
+func Call(ctx context.Context, n *maelstrom.Node, dest string, req Body) (maelstrom.Message, error) {
+	respCh := make(chan maelstrom.Message, 1)
+	if err := n.RPC(dest, typed{req}, func(m maelstrom.Message) error {
+		respCh <- m
+		return nil
+	}); err != nil {
+		return maelstrom.Message{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return maelstrom.Message{}, ctx.Err()
+	case m := <-respCh:
+		if err := m.RPCError(); err != nil {
+			return m, err
+		}
+		return m, nil
+	}
+}
+
+// RPC is like Call, but also decodes the response into Resp.
+func RPC[Resp Body](ctx context.Context, n *maelstrom.Node, dest string, req Body) (Resp, error) {
+	var resp Resp
+	msg, err := Call(ctx, n, dest, req)
+	if err != nil {
+		return resp, err
+	}
+	if msg.Type() != resp.Type() {
+		return resp, fmt.Errorf("unexpected response to %s: %s", req.Type(), msg.Type())
+	}
+	return Decode[Resp](msg)
+}
+
+// typed marshals a body with its type field.
+type typed struct {
+	Body
+}
+
+func (t typed) MarshalJSON() ([]byte, error) {
+	buf, err := json.Marshal(t.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 2 || buf[0] != '{' {
+		return nil, fmt.Errorf("%s body is not a JSON object", t.Type())
+	}
+	typ, err := json.Marshal(t.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"type":`)
+	b.Write(typ)
+	if !bytes.Equal(buf, []byte("{}")) {
+		b.WriteByte(',')
+	}
+	b.Write(buf[1:])
+	return b.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package proto
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+var (
+	handledMu   sync.Mutex
+	handled     = make(map[*maelstrom.Node]map[string]bool)
+	initialized = make(map[*maelstrom.Node]bool)
+)
+
+func register(n *maelstrom.Node, typ string) {
+	handledMu.Lock()
+	defer handledMu.Unlock()
+	if handled[n] == nil {
+		handled[n] = make(map[string]bool)
+	}
+	handled[n][typ] = true
+}
+
+func isHandled(n *maelstrom.Node, typ string) bool {
+	handledMu.Lock()
+	defer handledMu.Unlock()
+	return handled[n][typ]
+}
+
+// Initialized reports whether n has handled its init message. Goroutines
+// started before n.Run must check it before calling n.ID or n.NodeIDs, which
+// are not safe to call concurrently with init.
+func Initialized(n *maelstrom.Node) bool {
+	handledMu.Lock()
+	defer handledMu.Unlock()
+	return initialized[n]
+}
+
+// Run runs n like n.Run, except that a message n cannot handle does not stop
+// it: lines that are not valid messages are logged and dropped, and requests
+// of a type with no handler registered through Handle are answered with
+// NotSupported.
+func Run(n *maelstrom.Node) error {
+	defer func() {
+		handledMu.Lock()
+		delete(handled, n)
+		delete(initialized, n)
+		handledMu.Unlock()
+	}()
+
+	n.Handle("init", func(maelstrom.Message) error {
+		handledMu.Lock()
+		initialized[n] = true
+		handledMu.Unlock()
+		return nil
+	})
+
+	in := n.Stdin
+	r, w := io.Pipe()
+	n.Stdin = r
+	go func() {
+		w.CloseWithError(filter(n, in, w))
+	}()
+
+	err := n.Run()
+	r.Close()
+	return err
+}
+
+func filter(n *maelstrom.Node, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var msg maelstrom.Message
+		var body maelstrom.MessageBody
+		if err := json.Unmarshal(line, &msg); err != nil {
+			log.Printf("dropping invalid message %s: %s", line, err)
+			continue
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			log.Printf("dropping invalid message %s: %s", line, err)
+			continue
+		}
+
+		if body.InReplyTo == 0 && body.Type != "init" && !isHandled(n, body.Type) {
+			log.Printf("no handler for %s", line)
+			if body.MsgID != 0 {
+				if err := n.Reply(msg, NotSupported("unsupported message type %q", body.Type)); err != nil {
+					log.Printf("reply error: %s", err)
+				}
+			}
+			continue
+		}
+
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+		if _, err := out.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,159 @@
+// Package rtt measures the round trip time to every peer from the requests
+// sent to it, and derives request timeouts from the measurements the way TCP
+// derives its retransmission timeout (RFC 6298): a smoothed round trip time
+// plus four times its smoothed variation, doubled after every timeout until
+// the next measurement.
+//
+// Every attempt at a request has its own message id, so a response always
+// matches the attempt that it answers and every round trip can be measured.
+package rtt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// Options configure a Tracker. Zero values select the defaults.
+type Options struct {
+	// Initial is the timeout for peers that have not been measured yet.
+	// Defaults to 1 second.
+	Initial time.Duration
+
+	// Min and Max bound every timeout. They default to 200 milliseconds and
+	// 2 seconds.
+	Min time.Duration
+	Max time.Duration
+}
+
+// Tracker holds the estimates of every peer. It is safe for concurrent use.
+type Tracker struct {
+	clk  clock.Clock
+	opts Options
+
+	mu    sync.Mutex
+	peers map[string]*estimate
+}
+
+type estimate struct {
+	// measured is false until the first round trip, which an estimate
+	// created by a timeout has not seen yet.
+	measured     bool
+	srtt, rttvar time.Duration
+	// backoff is the number of timeouts since the last measurement.
+	backoff int
+}
+
+// New returns a tracker that has measured nothing yet.
+func New(clk clock.Clock, opts Options) *Tracker {
+	if opts.Initial == 0 {
+		opts.Initial = time.Second
+	}
+	if opts.Min == 0 {
+		opts.Min = 200 * time.Millisecond
+	}
+	if opts.Max == 0 {
+		opts.Max = 2 * time.Second
+	}
+	return &Tracker{clk: clk, opts: opts, peers: make(map[string]*estimate)}
+}
+
+// Observe records a round trip of d to peer and resets its backoff.
+func (t *Tracker) Observe(peer string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.peers[peer]
+	if !ok {
+		e = &estimate{}
+		t.peers[peer] = e
+	}
+	// The first round trip initializes the estimate, as in RFC 6298 2.2.
+	if !e.measured {
+		e.measured, e.srtt, e.rttvar = true, d, d/2
+	} else {
+		e.rttvar = (3*e.rttvar + (e.srtt - d).Abs()) / 4
+		e.srtt = (7*e.srtt + d) / 8
+	}
+	e.backoff = 0
+}
+
+// Expired records that a request to peer timed out, which doubles the
+// timeout of the next one.
+func (t *Tracker) Expired(peer string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.peers[peer]
+	if !ok {
+		e = &estimate{}
+		t.peers[peer] = e
+	}
+	e.backoff++
+}
+
+// SRTT returns the smoothed round trip time to peer, and false if it has not
+// been measured.
+func (t *Tracker) SRTT(peer string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.peers[peer]
+	if !ok || !e.measured {
+		return 0, false
+	}
+	return e.srtt, true
+}
+
+// Timeout returns the timeout for the next request to peer.
+func (t *Tracker) Timeout(peer string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.opts.Initial
+	e, ok := t.peers[peer]
+	if !ok {
+		return d
+	}
+	if e.measured {
+		d = max(e.srtt+4*e.rttvar, t.opts.Min)
+	}
+	for range e.backoff {
+		if d >= t.opts.Max {
+			break
+		}
+		d *= 2
+	}
+	return min(d, t.opts.Max)
+}
+
+// Call runs f, which makes one request to peer, with the timeout for peer,
+// and records the outcome. Any response, including an error, is a round
+// trip. Only the expiry of the timeout itself counts as a timeout, not the
+// end of ctx.
+func (t *Tracker) Call(ctx context.Context, peer string, f func(ctx context.Context) error) error {
+	callCtx, cancel := t.clk.WithTimeout(ctx, t.Timeout(peer))
+	defer cancel()
+	start := t.clk.Now()
+	err := f(callCtx)
+	switch {
+	case !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled):
+		t.Observe(peer, t.clk.Now().Sub(start))
+	case ctx.Err() == nil:
+		t.Expired(peer)
+	}
+	return err
+}
+
+// RPC sends req to dest with proto.RPC under a timeout derived from the
+// round trips measured to dest.
+func RPC[Resp proto.Body](ctx context.Context, t *Tracker, n *maelstrom.Node, dest string, req proto.Body) (Resp, error) {
+	var resp Resp
+	err := t.Call(ctx, dest, func(ctx context.Context) error {
+		var err error
+		resp, err = proto.RPC[Resp](ctx, n, dest, req)
+		return err
+	})
+	return resp, err
+}
@@ -0,0 +1,42 @@
+package rtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+)
+
+func TestObserveAfterExpired(t *testing.T) {
+	tr := New(clock.Real(), Options{})
+	tr.Expired("n1")
+	if got := tr.Timeout("n1"); got != 2*time.Second {
+		t.Fatalf("got timeout %v after a timeout, want the doubled initial one", got)
+	}
+
+	// The first sample sets srtt to 300ms and rttvar to 150ms, rather than
+	// being averaged with an empty estimate.
+	tr.Observe("n1", 300*time.Millisecond)
+	if got, ok := tr.SRTT("n1"); !ok || got != 300*time.Millisecond {
+		t.Fatalf("got srtt %v, %v, want 300ms", got, ok)
+	}
+	if got := tr.Timeout("n1"); got != 900*time.Millisecond {
+		t.Fatalf("got timeout %v, want 900ms", got)
+	}
+
+	tr.Observe("n1", 100*time.Millisecond)
+	if got, _ := tr.SRTT("n1"); got != 275*time.Millisecond {
+		t.Fatalf("got srtt %v, want 275ms", got)
+	}
+}
+
+func TestObserveZero(t *testing.T) {
+	tr := New(clock.Real(), Options{})
+	tr.Observe("n1", 0)
+	if _, ok := tr.SRTT("n1"); !ok {
+		t.Fatal("a round trip of 0 was not recorded")
+	}
+	if got := tr.Timeout("n1"); got != 200*time.Millisecond {
+		t.Fatalf("got timeout %v, want the minimum", got)
+	}
+}
@@ -0,0 +1,512 @@
+// Package sequencer orders messages with a replicated sequencer. One node at
+// a time is the sequencer: it appends every submitted message to its log and
+// replicates the log to the other nodes, and an entry is committed once a
+// majority of the nodes hold it. When the sequencer stops sending
+// heartbeats, the other nodes elect a new one for a higher term, and only a
+// node whose log holds every committed entry can win. This is the leader
+// election and log replication of Raft, without persistence, since Maelstrom
+// nodes do not restart.
+//
+// Committed entries are never rewritten, so the committed logs of any two
+// nodes are prefixes of one another.
+package sequencer
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/idset"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// entry is a message ordered in Term. A new sequencer appends a no-op entry,
+// which commits the entries of earlier terms along with it.
+type entry struct {
+	Term    int  `json:"term"`
+	Message int  `json:"message"`
+	Noop    bool `json:"noop,omitempty"`
+}
+
+// vote asks the receiver to make the sender the sequencer of Term.
+type vote struct {
+	Term      int `json:"term"`
+	LastIndex int `json:"last_index"`
+	LastTerm  int `json:"last_term"`
+}
+
+type voteOk struct {
+	Term    int  `json:"term"`
+	Granted bool `json:"granted"`
+}
+
+// appendEntries replicates the entries following PrevIndex. The sequencer
+// sends it as a heartbeat too.
+type appendEntries struct {
+	Term      int     `json:"term"`
+	PrevIndex int     `json:"prev_index"`
+	PrevTerm  int     `json:"prev_term"`
+	Entries   []entry `json:"entries"`
+	Commit    int     `json:"commit"`
+}
+
+// appendOk reports whether the entries were appended. Match is the last
+// index that matches the sequencer's log when they were, and where to retry
+// from otherwise.
+type appendOk struct {
+	Term    int  `json:"term"`
+	Success bool `json:"success"`
+	Match   int  `json:"match"`
+}
+
+// submit asks the sequencer to order Message.
+type submit struct {
+	Message int `json:"message"`
+}
+
+type submitOk struct{}
+
+func (vote) Type() string          { return "seq_vote" }
+func (voteOk) Type() string        { return "seq_vote_ok" }
+func (appendEntries) Type() string { return "seq_append" }
+func (appendOk) Type() string      { return "seq_append_ok" }
+func (submit) Type() string        { return "seq_submit" }
+func (submitOk) Type() string      { return "seq_submit_ok" }
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// maxEntries bounds the number of entries sent in one append.
+const maxEntries = 256
+
+// Options configure a Sequencer. Zero values select the defaults.
+type Options struct {
+	// Heartbeat is the time between two appends from the sequencer.
+	// Defaults to 100 milliseconds.
+	Heartbeat time.Duration
+
+	// ElectionTimeout is how long a node waits without hearing from the
+	// sequencer before it stands for election. Every wait is drawn between
+	// ElectionTimeout and twice that, so that nodes rarely stand at the same
+	// time. Defaults to 1 second.
+	ElectionTimeout time.Duration
+
+	// Timeout bounds every vote and append request. Defaults to 500
+	// milliseconds.
+	Timeout time.Duration
+}
+
+// Sequencer is a node's replica of the ordered log.
+type Sequencer struct {
+	n    *maelstrom.Node
+	clk  clock.Clock
+	opts Options
+
+	ticker clock.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	term     int
+	votedFor string
+	role     role
+	leader   string
+	deadline time.Time
+	rand     *rand.Rand
+
+	// log[0] is a sentinel, so that the first entry is at index 1.
+	log    []entry
+	commit int
+
+	// next and match are the sequencer's view of every follower: the next
+	// entry to send it and the last entry known to be replicated on it.
+	next     map[string]int
+	match    map[string]int
+	inflight map[string]bool
+	waiters  map[int]waiter
+
+	// delivered lists the committed messages in log order. A message
+	// submitted more than once is listed once.
+	delivered []int
+	seen      idset.Set
+}
+
+// waiter is a submission waiting for the entry it appended in term to be
+// committed. done receives whether it was.
+type waiter struct {
+	term int
+	done chan bool
+}
+
+// Start registers the sequencer handlers on n and starts the election timer.
+// It must be called before n.Run.
+func Start(n *maelstrom.Node, clk clock.Clock, opts Options) *Sequencer {
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = 100 * time.Millisecond
+	}
+	if opts.ElectionTimeout == 0 {
+		opts.ElectionTimeout = time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 500 * time.Millisecond
+	}
+	s := &Sequencer{
+		n:        n,
+		clk:      clk,
+		opts:     opts,
+		ticker:   clk.NewTicker(opts.Heartbeat),
+		done:     make(chan struct{}),
+		log:      []entry{{}},
+		next:     make(map[string]int),
+		match:    make(map[string]int),
+		inflight: make(map[string]bool),
+		waiters:  make(map[int]waiter),
+	}
+
+	proto.Handle(n, func(msg maelstrom.Message, req vote) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if req.Term > s.term {
+			s.stepDown(req.Term)
+		}
+		granted := req.Term == s.term && (s.votedFor == "" || s.votedFor == msg.Src) &&
+			s.upToDate(req.LastIndex, req.LastTerm)
+		if granted {
+			s.votedFor = msg.Src
+			s.resetDeadline()
+		}
+		return proto.Reply(n, msg, voteOk{Term: s.term, Granted: granted})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req appendEntries) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if req.Term < s.term {
+			return proto.Reply(n, msg, appendOk{Term: s.term})
+		}
+		if req.Term > s.term || s.role != follower {
+			s.stepDown(req.Term)
+		}
+		s.leader = msg.Src
+		s.resetDeadline()
+
+		if req.PrevIndex >= len(s.log) {
+			return proto.Reply(n, msg, appendOk{Term: s.term, Match: len(s.log) - 1})
+		}
+		if s.log[req.PrevIndex].Term != req.PrevTerm {
+			return proto.Reply(n, msg, appendOk{Term: s.term, Match: req.PrevIndex - 1})
+		}
+		for i, e := range req.Entries {
+			idx := req.PrevIndex + 1 + i
+			if idx < len(s.log) {
+				if s.log[idx].Term == e.Term {
+					continue
+				}
+				s.log = s.log[:idx]
+			}
+			s.log = append(s.log, e)
+		}
+		match := req.PrevIndex + len(req.Entries)
+		s.advance(min(req.Commit, match))
+		return proto.Reply(n, msg, appendOk{Term: s.term, Success: true, Match: match})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req submit) error {
+		s.mu.Lock()
+		if s.role != leader {
+			s.mu.Unlock()
+			return proto.Unavailable("%s is not the sequencer", n.ID())
+		}
+		w := s.propose(req.Message)
+		s.mu.Unlock()
+
+		ctx, cancel := clk.WithTimeout(context.Background(), opts.ElectionTimeout)
+		defer cancel()
+		if err := wait(ctx, w); err != nil {
+			return err
+		}
+		return proto.Reply(n, msg, submitOk{})
+	})
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Stop stops the timers and waits for the outstanding requests.
+func (s *Sequencer) Stop() {
+	s.ticker.Stop()
+	close(s.done)
+	s.wg.Wait()
+}
+
+// Submit orders msg and returns once it is committed. Submissions at other
+// nodes are forwarded to the sequencer. An error means msg may still be
+// committed, unless it is TemporarilyUnavailable.
+func (s *Sequencer) Submit(ctx context.Context, msg int) error {
+	s.mu.Lock()
+	if s.role == leader {
+		w := s.propose(msg)
+		s.mu.Unlock()
+		return wait(ctx, w)
+	}
+	dest := s.leader
+	s.mu.Unlock()
+
+	if dest == "" {
+		return proto.Unavailable("no sequencer is known")
+	}
+	_, err := proto.RPC[submitOk](ctx, s.n, dest, submit{msg})
+	return err
+}
+
+// Log returns the committed messages in order.
+func (s *Sequencer) Log() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int{}, s.delivered...)
+}
+
+func wait(ctx context.Context, w waiter) error {
+	select {
+	case ok := <-w.done:
+		if !ok {
+			return proto.Unavailable("overwritten by another sequencer")
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sequencer) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ticker.C():
+		}
+		if !proto.Initialized(s.n) {
+			continue
+		}
+
+		s.mu.Lock()
+		switch {
+		case s.role == leader:
+			s.replicate()
+		case s.deadline.IsZero():
+			s.resetDeadline()
+		case !s.clk.Now().Before(s.deadline):
+			s.campaign()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// propose appends msg to the log of the sequencer and starts replicating it.
+// s.mu must be held.
+func (s *Sequencer) propose(msg int) waiter {
+	s.log = append(s.log, entry{Term: s.term, Message: msg})
+	w := waiter{term: s.term, done: make(chan bool, 1)}
+	s.waiters[len(s.log)-1] = w
+	s.commitReplicated()
+	s.replicate()
+	return w
+}
+
+// campaign stands for election in the next term. s.mu must be held.
+func (s *Sequencer) campaign() {
+	s.term++
+	s.role = candidate
+	s.votedFor = s.n.ID()
+	s.leader = ""
+	s.resetDeadline()
+
+	term, votes := s.term, 1
+	if votes >= s.quorum() {
+		s.becomeLeader()
+		return
+	}
+	last := len(s.log) - 1
+	req := vote{Term: term, LastIndex: last, LastTerm: s.log[last].Term}
+	for _, peer := range s.peers() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			ctx, cancel := s.clk.WithTimeout(context.Background(), s.opts.Timeout)
+			defer cancel()
+			resp, err := proto.RPC[voteOk](ctx, s.n, peer, req)
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if resp.Term > s.term {
+				s.stepDown(resp.Term)
+				return
+			}
+			if s.role != candidate || s.term != term || !resp.Granted {
+				return
+			}
+			if votes++; votes >= s.quorum() {
+				s.becomeLeader()
+			}
+		}()
+	}
+}
+
+// becomeLeader makes the node the sequencer of the current term. s.mu must
+// be held.
+func (s *Sequencer) becomeLeader() {
+	s.role = leader
+	s.leader = s.n.ID()
+	for _, peer := range s.peers() {
+		s.next[peer] = len(s.log)
+		s.match[peer] = 0
+		s.inflight[peer] = false
+	}
+	s.log = append(s.log, entry{Term: s.term, Noop: true})
+	s.commitReplicated()
+	s.replicate()
+}
+
+// stepDown makes the node a follower, moving to term if it is newer. s.mu
+// must be held.
+func (s *Sequencer) stepDown(term int) {
+	if term > s.term {
+		s.term = term
+		s.votedFor = ""
+		s.leader = ""
+	}
+	s.role = follower
+}
+
+// replicate sends the entries every follower is missing, or a heartbeat if
+// it is missing none, to the followers without an outstanding append. s.mu
+// must be held.
+func (s *Sequencer) replicate() {
+	for _, peer := range s.peers() {
+		if s.inflight[peer] {
+			continue
+		}
+		s.inflight[peer] = true
+		next := s.next[peer]
+		req := appendEntries{
+			Term:      s.term,
+			PrevIndex: next - 1,
+			PrevTerm:  s.log[next-1].Term,
+			Entries:   slices.Clone(s.log[next:min(len(s.log), next+maxEntries)]),
+			Commit:    s.commit,
+		}
+		s.wg.Add(1)
+		go s.sendAppend(peer, req)
+	}
+}
+
+func (s *Sequencer) sendAppend(peer string, req appendEntries) {
+	defer s.wg.Done()
+	ctx, cancel := s.clk.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	resp, err := proto.RPC[appendOk](ctx, s.n, peer, req)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight[peer] = false
+	if err != nil {
+		return
+	}
+	if resp.Term > s.term {
+		s.stepDown(resp.Term)
+		return
+	}
+	if s.role != leader || s.term != req.Term {
+		return
+	}
+	if !resp.Success {
+		s.next[peer] = max(1, min(resp.Match+1, req.PrevIndex))
+		return
+	}
+	s.match[peer] = max(s.match[peer], resp.Match)
+	s.next[peer] = s.match[peer] + 1
+	s.commitReplicated()
+}
+
+// commitReplicated commits the newest entry of the current term that a
+// majority holds. Entries of earlier terms are only committed along with
+// one of the current term, since a majority holding them does not stop a
+// later sequencer from overwriting them. s.mu must be held.
+func (s *Sequencer) commitReplicated() {
+	for i := len(s.log) - 1; i > s.commit && s.log[i].Term == s.term; i-- {
+		count := 1
+		for _, m := range s.match {
+			if m >= i {
+				count++
+			}
+		}
+		if count >= s.quorum() {
+			s.advance(i)
+			return
+		}
+	}
+}
+
+// advance commits the entries up to commit and delivers their messages.
+// s.mu must be held.
+func (s *Sequencer) advance(commit int) {
+	for ; s.commit < commit; s.commit++ {
+		i := s.commit + 1
+		e := s.log[i]
+		if w, ok := s.waiters[i]; ok {
+			w.done <- e.Term == w.term
+			delete(s.waiters, i)
+		}
+		if !e.Noop && s.seen.Add(e.Message) {
+			s.delivered = append(s.delivered, e.Message)
+		}
+	}
+}
+
+// upToDate reports whether a log ending at index with term holds at least
+// every entry of the node's log that may be committed. s.mu must be held.
+func (s *Sequencer) upToDate(index, term int) bool {
+	last := len(s.log) - 1
+	if term != s.log[last].Term {
+		return term > s.log[last].Term
+	}
+	return index >= last
+}
+
+// resetDeadline restarts the election timer. s.mu must be held.
+func (s *Sequencer) resetDeadline() {
+	if s.rand == nil {
+		h := fnv.New64a()
+		h.Write([]byte(s.n.ID()))
+		s.rand = rand.New(rand.NewSource(int64(h.Sum64())))
+	}
+	jitter := time.Duration(s.rand.Int63n(int64(s.opts.ElectionTimeout)))
+	s.deadline = s.clk.Now().Add(s.opts.ElectionTimeout + jitter)
+}
+
+func (s *Sequencer) peers() []string {
+	var peers []string
+	for _, node := range s.n.NodeIDs() {
+		if node != s.n.ID() {
+			peers = append(peers, node)
+		}
+	}
+	return peers
+}
+
+func (s *Sequencer) quorum() int {
+	return len(s.n.NodeIDs())/2 + 1
+}
@@ -0,0 +1,238 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// Client sends requests to nodes the way a Maelstrom client does.
+type Client struct {
+	id  string
+	net *Network
+
+	mu        sync.Mutex
+	nextMsgID int
+	pending   map[int]chan maelstrom.Message
+}
+
+func newClient(id string, net *Network) *Client {
+	return &Client{
+		id:      id,
+		net:     net,
+		pending: make(map[int]chan maelstrom.Message),
+	}
+}
+
+// ID returns the client's id.
+func (c *Client) ID() string {
+	return c.id
+}
+
+// RPC sends body to dest and waits for the reply. Error replies are returned
+// as *maelstrom.RPCError. On a deterministic network RPC steps the network
+// until the reply arrives, so only one goroutine may drive it at a time.
+func (c *Client) RPC(ctx context.Context, dest string, body any) (maelstrom.Message, error) {
+	call, err := c.send(dest, body)
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+	return c.await(ctx, call)
+}
+
+type call struct {
+	msgID  int
+	respCh chan maelstrom.Message
+}
+
+func (c *Client) send(dest string, body any) (*call, error) {
+	b := make(map[string]any)
+	if buf, err := json.Marshal(body); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(buf, &b); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.nextMsgID++
+	call := &call{msgID: c.nextMsgID, respCh: make(chan maelstrom.Message, 1)}
+	c.pending[call.msgID] = call.respCh
+	c.mu.Unlock()
+
+	b["msg_id"] = call.msgID
+	bodyJSON, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(maelstrom.Message{Src: c.id, Dest: dest, Body: bodyJSON})
+	if err != nil {
+		return nil, err
+	}
+	c.net.route(line)
+	return call, nil
+}
+
+func (c *Client) await(ctx context.Context, call *call) (maelstrom.Message, error) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, call.msgID)
+		c.mu.Unlock()
+	}()
+
+	var m maelstrom.Message
+	if c.net.virtual != nil {
+	loop:
+		for {
+			select {
+			case m = <-call.respCh:
+				break loop
+			default:
+			}
+			if err := ctx.Err(); err != nil {
+				return maelstrom.Message{}, err
+			}
+			if !c.net.Step() {
+				return maelstrom.Message{}, ErrDeadlock
+			}
+		}
+	} else {
+		select {
+		case <-ctx.Done():
+			return maelstrom.Message{}, ctx.Err()
+		case m = <-call.respCh:
+		}
+	}
+
+	if err := m.RPCError(); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func (c *Client) deliver(line []byte) {
+	var msg maelstrom.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return
+	}
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	respCh, ok := c.pending[body.InReplyTo]
+	c.mu.Unlock()
+	if ok {
+		respCh <- msg
+	}
+}
+
+func (c *Client) call(ctx context.Context, dest string, req any, resp any) error {
+	msg, err := c.RPC(ctx, dest, req)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(msg.Body, resp); err != nil {
+		return fmt.Errorf("unmarshal %s reply: %w", msg.Type(), err)
+	}
+	return nil
+}
+
+// Echo sends an echo request and returns the echoed string.
+func (c *Client) Echo(ctx context.Context, dest, echo string) (string, error) {
+	var resp struct {
+		Echo string `json:"echo"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "echo", "echo": echo}, &resp)
+	return resp.Echo, err
+}
+
+// Generate requests a unique id.
+func (c *Client) Generate(ctx context.Context, dest string) (any, error) {
+	var resp struct {
+		ID any `json:"id"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "generate"}, &resp)
+	return resp.ID, err
+}
+
+// Topology sends the broadcast topology to dest.
+func (c *Client) Topology(ctx context.Context, dest string, topology map[string][]string) error {
+	return c.call(ctx, dest, map[string]any{"type": "topology", "topology": topology}, nil)
+}
+
+// Broadcast sends a broadcast request for message.
+func (c *Client) Broadcast(ctx context.Context, dest string, message int) error {
+	return c.call(ctx, dest, map[string]any{"type": "broadcast", "message": message}, nil)
+}
+
+// ReadMessages sends a broadcast read request and returns the messages.
+func (c *Client) ReadMessages(ctx context.Context, dest string) ([]int, error) {
+	var resp struct {
+		Messages []int `json:"messages"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "read"}, &resp)
+	return resp.Messages, err
+}
+
+// Add sends a counter add request.
+func (c *Client) Add(ctx context.Context, dest string, delta int) error {
+	return c.call(ctx, dest, map[string]any{"type": "add", "delta": delta}, nil)
+}
+
+// ReadValue sends a counter read request and returns the value.
+func (c *Client) ReadValue(ctx context.Context, dest string) (int, error) {
+	var resp struct {
+		Value int `json:"value"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "read"}, &resp)
+	return resp.Value, err
+}
+
+// Send appends msg to the kafka log for key and returns its offset.
+func (c *Client) Send(ctx context.Context, dest, key string, msg int) (int, error) {
+	var resp struct {
+		Offset int `json:"offset"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "send", "key": key, "msg": msg}, &resp)
+	return resp.Offset, err
+}
+
+// Poll returns the [offset, msg] pairs of each log starting at offsets.
+func (c *Client) Poll(ctx context.Context, dest string, offsets map[string]int) (map[string][][2]int, error) {
+	var resp struct {
+		Msgs map[string][][2]int `json:"msgs"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "poll", "offsets": offsets}, &resp)
+	return resp.Msgs, err
+}
+
+// CommitOffsets commits the given offsets.
+func (c *Client) CommitOffsets(ctx context.Context, dest string, offsets map[string]int) error {
+	return c.call(ctx, dest, map[string]any{"type": "commit_offsets", "offsets": offsets}, nil)
+}
+
+// ListCommittedOffsets returns the committed offsets of keys.
+func (c *Client) ListCommittedOffsets(ctx context.Context, dest string, keys []string) (map[string]int, error) {
+	var resp struct {
+		Offsets map[string]int `json:"offsets"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "list_committed_offsets", "keys": keys}, &resp)
+	return resp.Offsets, err
+}
+
+// Txn runs a transaction and returns the completed operations.
+func (c *Client) Txn(ctx context.Context, dest string, txn []proto.Op) ([]proto.Op, error) {
+	var resp struct {
+		Txn []proto.Op `json:"txn"`
+	}
+	err := c.call(ctx, dest, map[string]any{"type": "txn", "txn": txn}, &resp)
+	return resp.Txn, err
+}
@@ -0,0 +1,514 @@
+// Package sim runs Maelstrom node programs in a single process, connected by a
+// simulated network with configurable latency, message loss and partitions.
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os/exec"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+)
+
+// Program registers handlers on a node and runs it until its input is closed.
+type Program func(n *maelstrom.Node) error
+
+// Config describes the behaviour of the simulated network.
+type Config struct {
+	// Latency is the mean one-way delay of a message. Delays are
+	// exponentially distributed, like Maelstrom's --latency option.
+	Latency time.Duration
+
+	// DropRate is the probability that a message between two nodes is lost.
+	DropRate float64
+
+	// Seed seeds the random source used for delays and drops.
+	Seed int64
+
+	// ShutdownTimeout bounds how long Close waits for node programs to
+	// return. Defaults to 5 seconds.
+	ShutdownTimeout time.Duration
+
+	// Deterministic runs the network on a virtual clock. Messages and
+	// timers are processed one at a time, in an order that depends only on
+	// Seed, and time only moves when the network is stepped. Programs must
+	// use Network.Clock for all timing, and only nodes added with AddNode
+	// are supported. Before every event the network waits for all
+	// goroutines in the process to block, so it must not share the process
+	// with goroutines that keep running.
+	Deterministic bool
+
+	// Trace, if set, receives every message the network delivers, one per
+	// line, in delivery order.
+	Trace io.Writer
+}
+
+// Stats counts the messages routed through the network.
+type Stats struct {
+	ClientMsgs  int
+	ServerMsgs  int
+	ServiceMsgs int
+	Dropped     int
+}
+
+type endpoint interface {
+	deliver(line []byte)
+}
+
+// Network routes messages between nodes and clients.
+type Network struct {
+	cfg Config
+
+	clock   clock.Clock
+	virtual *clock.Virtual
+
+	mu         sync.Mutex
+	rand       *rand.Rand
+	endpoints  map[string]endpoint
+	nodes      []*node
+	nodeIDs    []string
+	partitions map[string]int
+	byzantine  map[string]Tamper
+	stats      Stats
+	nextClient int
+	closed     bool
+
+	traceMu sync.Mutex
+
+	// Deterministic mode only.
+	pending   [][]byte
+	events    eventHeap
+	nextSeq   int
+	nextMsgID int
+	msgIDs    map[msgRef]any
+	stacks    []byte
+}
+
+type node struct {
+	id   string
+	done chan error
+	kill func()
+
+	mu sync.Mutex
+	in io.WriteCloser
+}
+
+// New returns an empty network.
+func New(cfg Config) *Network {
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 5 * time.Second
+	}
+	net := &Network{
+		cfg:       cfg,
+		clock:     clock.Real(),
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		endpoints: make(map[string]endpoint),
+	}
+	if cfg.Deterministic {
+		net.virtual = clock.NewVirtual(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		net.clock = net.virtual
+		net.msgIDs = make(map[msgRef]any)
+		net.stacks = make([]byte, 64<<10)
+	}
+	return net
+}
+
+// Clock returns the clock the programs on this network must use.
+func (net *Network) Clock() clock.Clock {
+	return net.clock
+}
+
+// Deterministic reports whether the network runs on a virtual clock.
+func (net *Network) Deterministic() bool {
+	return net.virtual != nil
+}
+
+// AddNode starts prog on a new node with the given id. The node is not
+// initialized until Start is called.
+func (net *Network) AddNode(id string, prog Program) {
+	r, w := io.Pipe()
+	nd := &node{id: id, in: w, done: make(chan error, 1)}
+
+	n := maelstrom.NewNode()
+	n.Stdin = r
+	n.Stdout = newLineWriter(net.route)
+	net.addNode(nd)
+
+	go func() {
+		err := prog(n)
+		r.Close()
+		nd.done <- err
+	}()
+}
+
+// AddCommand starts cmd as a new node with the given id, the way Maelstrom
+// runs a node binary. cmd's Stdin and Stdout must not be set. Commands run on
+// the wall clock, so they cannot be added to a deterministic network.
+func (net *Network) AddCommand(id string, cmd *exec.Cmd) error {
+	if net.virtual != nil {
+		return errors.New("sim: commands cannot run on a deterministic network")
+	}
+	cmd.Stdout = newLineWriter(net.route)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	nd := &node{id: id, in: in, done: make(chan error, 1), kill: func() { cmd.Process.Kill() }}
+	net.addNode(nd)
+
+	go func() {
+		nd.done <- cmd.Wait()
+	}()
+	return nil
+}
+
+func (net *Network) addNode(nd *node) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.endpoints[nd.id] = nd
+	net.nodes = append(net.nodes, nd)
+	net.nodeIDs = append(net.nodeIDs, nd.id)
+}
+
+// AddNodes adds count nodes named n0, n1, ... running prog.
+func (net *Network) AddNodes(count int, prog Program) {
+	for i := range count {
+		net.AddNode(fmt.Sprintf("n%d", i), prog)
+	}
+}
+
+// NodeIDs returns the ids of all nodes in the order they were added.
+func (net *Network) NodeIDs() []string {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return append([]string(nil), net.nodeIDs...)
+}
+
+// Start sends the init message to every node and waits for all of them to
+// acknowledge it.
+func (net *Network) Start(ctx context.Context) error {
+	c := net.Client()
+	ids := net.NodeIDs()
+	calls := make([]*call, 0, len(ids))
+	for _, id := range ids {
+		call, err := c.send(id, maelstrom.InitMessageBody{
+			MessageBody: maelstrom.MessageBody{Type: "init"},
+			NodeID:      id,
+			NodeIDs:     ids,
+		})
+		if err != nil {
+			return err
+		}
+		calls = append(calls, call)
+	}
+
+	var err error
+	for i, call := range calls {
+		if _, e := c.await(ctx, call); e != nil {
+			err = errors.Join(err, fmt.Errorf("init %s: %w", ids[i], e))
+		}
+	}
+	return err
+}
+
+// Client returns a new client with a unique id (c1, c2, ...).
+func (net *Network) Client() *Client {
+	net.mu.Lock()
+	net.nextClient++
+	id := fmt.Sprintf("c%d", net.nextClient)
+	net.mu.Unlock()
+
+	c := newClient(id, net)
+	net.mu.Lock()
+	net.endpoints[id] = c
+	net.mu.Unlock()
+	return c
+}
+
+// Partition splits the nodes into the given groups. Messages between nodes in
+// different groups are dropped; nodes not listed in any group are isolated.
+// Clients are never partitioned.
+func (net *Network) Partition(groups ...[]string) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.partitions = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			net.partitions[id] = i + 1
+		}
+	}
+}
+
+// Isolate cuts the given nodes off from every other node.
+func (net *Network) Isolate(ids ...string) {
+	groups := make([][]string, 0, len(ids)+1)
+	var rest []string
+	for _, id := range net.NodeIDs() {
+		isolated := false
+		for _, iso := range ids {
+			if id == iso {
+				isolated = true
+				break
+			}
+		}
+		if !isolated {
+			rest = append(rest, id)
+		}
+	}
+	groups = append(groups, rest)
+	for _, id := range ids {
+		groups = append(groups, []string{id})
+	}
+	net.Partition(groups...)
+}
+
+// Heal removes all partitions.
+func (net *Network) Heal() {
+	net.mu.Lock()
+	net.partitions = nil
+	net.mu.Unlock()
+}
+
+// Tamper rewrites the body of a message that a byzantine node sends to the
+// node dest. Numbers in body are json.Numbers.
+type Tamper func(dest string, body map[string]any) map[string]any
+
+// Byzantine makes the node with the given id faulty: every message it sends
+// to another node is passed through tamper on the way.
+func (net *Network) Byzantine(id string, tamper Tamper) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	if net.byzantine == nil {
+		net.byzantine = make(map[string]Tamper)
+	}
+	net.byzantine[id] = tamper
+}
+
+// Equivocate returns a Tamper that negates the integer field of the bodies
+// sent to the nodes at odd indices of nodes, so that a byzantine node tells
+// half of the nodes one value and the other half another.
+func Equivocate(nodes []string, field string) Tamper {
+	odd := make(map[string]bool)
+	for i, node := range nodes {
+		odd[node] = i%2 == 1
+	}
+	return func(dest string, body map[string]any) map[string]any {
+		if num, ok := body[field].(json.Number); ok && odd[dest] {
+			if v, err := num.Int64(); err == nil {
+				body[field] = -v
+			}
+		}
+		return body
+	}
+}
+
+// Stats returns the message counters accumulated so far.
+func (net *Network) Stats() Stats {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	return net.stats
+}
+
+// Close stops every node and waits for their programs to return.
+func (net *Network) Close() error {
+	net.mu.Lock()
+	net.closed = true
+	nodes := net.nodes
+	net.mu.Unlock()
+
+	for _, nd := range nodes {
+		nd.in.Close()
+	}
+
+	if net.virtual != nil {
+		// Keep firing timers so that handlers waiting on a timeout can
+		// return.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Millisecond):
+					net.virtual.Fire()
+				}
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), net.cfg.ShutdownTimeout)
+	defer cancel()
+	var err error
+	for _, nd := range nodes {
+		select {
+		case e := <-nd.done:
+			if e != nil {
+				err = errors.Join(err, fmt.Errorf("node %s: %w", nd.id, e))
+			}
+		case <-ctx.Done():
+			err = errors.Join(err, fmt.Errorf("node %s: did not shut down", nd.id))
+			if nd.kill != nil {
+				nd.kill()
+			}
+		}
+	}
+	return err
+}
+
+func (net *Network) route(line []byte) {
+	var msg maelstrom.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		log.Printf("sim: malformed message %q: %s", line, err)
+		return
+	}
+	line = net.tamper(msg, line)
+
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	if net.closed {
+		return
+	}
+
+	if net.virtual != nil {
+		net.pending = append(net.pending, append([]byte(nil), line...))
+		return
+	}
+
+	dest, delay, ok := net.dispatch(msg)
+	if !ok {
+		return
+	}
+	buf := append([]byte(nil), line...)
+	time.AfterFunc(delay, func() {
+		net.trace(buf)
+		dest.deliver(buf)
+	})
+}
+
+// trace writes line to the Trace writer, if any.
+func (net *Network) trace(line []byte) {
+	if net.cfg.Trace == nil {
+		return
+	}
+	net.traceMu.Lock()
+	defer net.traceMu.Unlock()
+	net.cfg.Trace.Write(append(line, '\n'))
+}
+
+// tamper returns line, which encodes msg, as rewritten by the Tamper of its
+// source if that is a byzantine node and its destination another node.
+func (net *Network) tamper(msg maelstrom.Message, line []byte) []byte {
+	net.mu.Lock()
+	tamper := net.byzantine[msg.Src]
+	_, destIsNode := net.endpoints[msg.Dest].(*node)
+	net.mu.Unlock()
+	if tamper == nil || !destIsNode {
+		return line
+	}
+
+	var body map[string]any
+	dec := json.NewDecoder(bytes.NewReader(msg.Body))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return line
+	}
+	buf, err := json.Marshal(tamper(msg.Dest, body))
+	if err != nil {
+		log.Printf("sim: tampered body of %s: %s", msg.Type(), err)
+		return line
+	}
+	msg.Body = buf
+	tampered, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("sim: tampered message %s: %s", msg.Type(), err)
+		return line
+	}
+	return tampered
+}
+
+// dispatch decides the fate of msg: which endpoint receives it and after what
+// delay. It reports false if the message is lost. Callers must hold net.mu.
+func (net *Network) dispatch(msg maelstrom.Message) (endpoint, time.Duration, bool) {
+	dest, ok := net.endpoints[msg.Dest]
+	if !ok {
+		log.Printf("sim: no endpoint for %s", msg.Dest)
+		return nil, 0, false
+	}
+
+	_, srcIsNode := net.endpoints[msg.Src].(*node)
+	_, destIsNode := dest.(*node)
+	_, srcIsService := net.endpoints[msg.Src].(*service)
+	_, destIsService := dest.(*service)
+	if srcIsService || destIsService {
+		net.stats.ServiceMsgs++
+	} else if srcIsNode && destIsNode {
+		net.stats.ServerMsgs++
+		if !net.connected(msg.Src, msg.Dest) || net.rand.Float64() < net.cfg.DropRate {
+			net.stats.Dropped++
+			return nil, 0, false
+		}
+	} else {
+		net.stats.ClientMsgs++
+	}
+
+	delay := time.Duration(net.rand.ExpFloat64() * float64(net.cfg.Latency))
+	return dest, delay, true
+}
+
+func (net *Network) connected(a, b string) bool {
+	if net.partitions == nil {
+		return true
+	}
+	ga, gb := net.partitions[a], net.partitions[b]
+	return ga != 0 && ga == gb
+}
+
+func (nd *node) deliver(line []byte) {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	// An error means the node has shut down and the message is lost.
+	nd.in.Write(append(line, '\n'))
+}
+
+// lineWriter splits the bytes written to it into newline-terminated lines.
+type lineWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	onLine func(line []byte)
+}
+
+func newLineWriter(onLine func(line []byte)) *lineWriter {
+	return &lineWriter{onLine: onLine}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		advance, line, _ := bufio.ScanLines(w.buf, false)
+		if advance == 0 {
+			break
+		}
+		if len(line) > 0 {
+			w.onLine(line)
+		}
+		w.buf = w.buf[advance:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,283 @@
+package sim
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"slices"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// ErrDeadlock is returned by client calls on a deterministic network when the
+// reply can never arrive because there are no messages or timers left.
+var ErrDeadlock = errors.New("sim: no pending messages or timers")
+
+// Step processes the next message delivery, or the timers due next, on a
+// deterministic network, advancing the virtual clock to it. It waits for the
+// programs to settle first, so that everything caused by the previous step
+// has been scheduled. Step reports false if there is nothing left to do, and always
+// returns false on a network that is not deterministic.
+func (net *Network) Step() bool {
+	if net.virtual == nil {
+		return false
+	}
+	net.settle()
+	net.flush()
+
+	net.mu.Lock()
+	var ev *event
+	at, hasTimer := net.virtual.Next()
+	if len(net.events) > 0 && (!hasTimer || !net.events[0].at.After(at)) {
+		ev = heap.Pop(&net.events).(*event)
+	}
+	net.mu.Unlock()
+
+	if ev != nil {
+		net.virtual.Advance(ev.at)
+		net.trace(ev.traced)
+		ev.dest.deliver(ev.line)
+		return true
+	}
+	return net.fire()
+}
+
+// fire runs every timer that expires at the instant of the earliest one, in
+// the order they were created, and lets the programs settle after each, so
+// that goroutines woken by different timers never race. The messages they
+// send are only flushed by the next step, together. It reports whether a
+// timer was fired.
+func (net *Network) fire() bool {
+	at, ok := net.virtual.Next()
+	if !ok {
+		return false
+	}
+	for next := at; ok && next.Equal(at); next, ok = net.virtual.Next() {
+		net.virtual.Fire()
+		net.settle()
+	}
+	return true
+}
+
+// Sleep lets d pass on the network's clock. On a deterministic network it
+// steps through every event due within d.
+func (net *Network) Sleep(d time.Duration) {
+	if net.virtual == nil {
+		time.Sleep(d)
+		return
+	}
+
+	deadline := net.virtual.Now().Add(d)
+	for {
+		net.settle()
+		net.flush()
+		next, ok := net.next()
+		if !ok || next.After(deadline) {
+			break
+		}
+		net.Step()
+	}
+	net.virtual.Advance(deadline)
+}
+
+func (net *Network) next() (time.Time, bool) {
+	at, ok := net.virtual.Next()
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	if len(net.events) > 0 && (!ok || net.events[0].at.Before(at)) {
+		return net.events[0].at, true
+	}
+	return at, ok
+}
+
+// settle waits until every goroutine other than the caller is blocked, so
+// that the programs have finished handling the last event and sent everything
+// it caused. Nothing on a deterministic network can wake them up again until
+// the next event.
+func (net *Network) settle() {
+	for !net.quiescent() {
+		runtime.Gosched()
+	}
+}
+
+// quiescent reports whether no goroutine other than the caller is running or
+// ready to run. It looks at every goroutine in the process, so goroutines
+// unrelated to the network delay it too.
+func (net *Network) quiescent() bool {
+	n := runtime.Stack(net.stacks, true)
+	for n == len(net.stacks) {
+		net.stacks = make([]byte, 2*len(net.stacks))
+		n = runtime.Stack(net.stacks, true)
+	}
+
+	// The caller's goroutine is always listed first.
+	goroutines := bytes.Split(net.stacks[:n], []byte("\n\n"))
+	for _, g := range goroutines[1:] {
+		header, _, _ := bytes.Cut(g, []byte("\n"))
+		_, state, _ := bytes.Cut(header, []byte("["))
+		state, _, _ = bytes.Cut(state, []byte("]"))
+		state, _, _ = bytes.Cut(state, []byte(","))
+		if !idle[string(state)] {
+			// The os/signal goroutine waits for signals in a system call.
+			if string(state) != "syscall" || !bytes.Contains(g, []byte("os/signal.signal_recv")) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// idle lists the states of goroutines that can only be woken up by another
+// goroutine or by the network. Everything else, including the states of
+// goroutines waiting for the garbage collector, counts as busy.
+var idle = map[string]bool{
+	"chan receive":            true,
+	"chan receive (nil chan)": true,
+	"chan send":               true,
+	"chan send (nil chan)":    true,
+	"select":                  true,
+	"select (no cases)":       true,
+	"semacquire":              true,
+	"sync.Cond.Wait":          true,
+	"sync.Mutex.Lock":         true,
+	"sync.RWMutex.Lock":       true,
+	"sync.RWMutex.RLock":      true,
+	"sync.WaitGroup.Wait":     true,
+	"IO wait":                 true,
+	"sleep":                   true,
+	"finalizer wait":          true,
+}
+
+// flush schedules the messages sent since the last flush. They are sorted
+// first so that the order in which concurrent goroutines happened to send
+// them does not affect the random draws. The msg_ids that nodes pick depend
+// on that order too, so they are left out of the sort and replaced by ids the
+// network hands out in sorted order; replies are mapped back to the ids the
+// node picked.
+func (net *Network) flush() {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+
+	type outgoing struct {
+		msg  maelstrom.Message
+		body map[string]any
+		key  []byte
+	}
+	var pending []outgoing
+	for _, line := range net.pending {
+		var msg maelstrom.Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			continue
+		}
+		var body map[string]any
+		dec := json.NewDecoder(bytes.NewReader(msg.Body))
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			continue
+		}
+		msgID, ok := body["msg_id"]
+		delete(body, "msg_id")
+		stripped, err := json.Marshal(body)
+		if err != nil {
+			continue
+		}
+		if ok {
+			body["msg_id"] = msgID
+		}
+		key := append([]byte(msg.Src+" "+msg.Dest+" "), stripped...)
+		pending = append(pending, outgoing{msg, body, key})
+	}
+	net.pending = nil
+	slices.SortStableFunc(pending, func(a, b outgoing) int { return bytes.Compare(a.key, b.key) })
+
+	now := net.virtual.Now()
+	for _, out := range pending {
+		line, traced, ok := net.renumber(out.msg, out.body)
+		if !ok {
+			continue
+		}
+		dest, delay, ok := net.dispatch(out.msg)
+		if !ok {
+			continue
+		}
+		heap.Push(&net.events, &event{at: now.Add(delay), seq: net.nextSeq, dest: dest, line: line, traced: traced})
+		net.nextSeq++
+	}
+}
+
+// renumber replaces the msg_id of a request from a node with the next network
+// id, and the in_reply_to of a reply to a node with the msg_id the node
+// picked for the request. It returns the rewritten message, and the message
+// with the network's ids only for the trace. Callers must hold net.mu.
+func (net *Network) renumber(msg maelstrom.Message, body map[string]any) (line, traced []byte, ok bool) {
+	if _, ok := net.endpoints[msg.Src].(*node); ok && body["msg_id"] != nil {
+		net.nextMsgID++
+		net.msgIDs[msgRef{msg.Src, net.nextMsgID}] = body["msg_id"]
+		body["msg_id"] = net.nextMsgID
+	}
+	if traced, ok = marshalMessage(msg, body); !ok {
+		return nil, nil, false
+	}
+
+	if num, ok := body["in_reply_to"].(json.Number); ok {
+		if id, err := num.Int64(); err == nil {
+			ref := msgRef{msg.Dest, int(id)}
+			if orig, ok := net.msgIDs[ref]; ok {
+				body["in_reply_to"] = orig
+				delete(net.msgIDs, ref)
+			}
+		}
+	}
+
+	line, ok = marshalMessage(msg, body)
+	return line, traced, ok
+}
+
+func marshalMessage(msg maelstrom.Message, body map[string]any) ([]byte, bool) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, false
+	}
+	msg.Body = buf
+	line, err := json.Marshal(msg)
+	return line, err == nil
+}
+
+// msgRef names the request with the given network msg_id sent by node.
+type msgRef struct {
+	node  string
+	msgID int
+}
+
+type event struct {
+	at     time.Time
+	seq    int
+	dest   endpoint
+	line   []byte
+	traced []byte
+}
+
+type eventHeap []*event
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *eventHeap) Push(x any) { *h = append(*h, x.(*event)) }
+
+func (h *eventHeap) Pop() any {
+	old := *h
+	ev := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return ev
+}
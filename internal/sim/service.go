@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"encoding/json"
+	"log"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Service stands in for a service that Maelstrom provides to the nodes, such
+// as seq-kv. Handle is called for every request and returns the reply body;
+// it may be called concurrently.
+type Service interface {
+	Handle(src string, body json.RawMessage) any
+}
+
+// AddService registers svc under id, e.g. "seq-kv". Services are never
+// partitioned and never lose messages.
+func (net *Network) AddService(id string, svc Service) {
+	net.mu.Lock()
+	defer net.mu.Unlock()
+	net.endpoints[id] = &service{id: id, svc: svc, net: net}
+}
+
+type service struct {
+	id  string
+	svc Service
+	net *Network
+}
+
+func (s *service) deliver(line []byte) {
+	var msg maelstrom.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return
+	}
+	var req maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return
+	}
+
+	b := make(map[string]any)
+	if buf, err := json.Marshal(s.svc.Handle(msg.Src, msg.Body)); err != nil {
+		log.Printf("sim: marshal %s reply: %s", s.id, err)
+		return
+	} else if err := json.Unmarshal(buf, &b); err != nil {
+		log.Printf("sim: marshal %s reply: %s", s.id, err)
+		return
+	}
+	b["in_reply_to"] = req.MsgID
+
+	body, err := json.Marshal(b)
+	if err != nil {
+		return
+	}
+	resp, err := json.Marshal(maelstrom.Message{Src: s.id, Dest: msg.Src, Body: body})
+	if err != nil {
+		return
+	}
+	s.net.route(resp)
+}
@@ -0,0 +1,46 @@
+// Package simtest runs challenges against the local workloads from tests.
+package simtest
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/workload"
+)
+
+// Run starts count nodes on a network configured by cfg, with the key/value
+// services registered, runs the workload described by opts against them and
+// fails t if the history has anomalies. run is called with the network's
+// clock for every node. The nodes' logs are only shown with go test -v.
+func Run(t testing.TB, cfg sim.Config, count int, run func(n *maelstrom.Node, clk clock.Clock) error, opts workload.Options) workload.Report {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("runs a workload for", opts.TimeLimit)
+	}
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+		t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	}
+
+	net := sim.New(cfg)
+	workload.AddServices(net, cfg.Seed)
+	net.AddNodes(count, func(n *maelstrom.Node) error { return run(n, net.Clock()) })
+
+	report, err := workload.Run(context.Background(), net, opts)
+	if closeErr := net.Close(); closeErr != nil {
+		t.Errorf("close: %v", closeErr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Result.Valid {
+		t.Fatal(report.Result)
+	}
+	return report
+}
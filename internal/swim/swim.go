@@ -0,0 +1,460 @@
+// Package swim detects failed nodes with the SWIM protocol. Every interval a
+// node pings members in a shuffled round-robin order, as many as it takes to
+// ping every member within RoundTime. If a member does not answer in time, a
+// few others are asked to ping it on the node's behalf, and if none of them
+// reaches it either, it is suspected. A suspect that does not refute the
+// suspicion within SuspectTimeout is declared dead. Changes to the view are
+// piggybacked on the pings and acks rather than sent on their own.
+//
+// A member is probed at least once every two rounds, so a failed member is
+// suspected within 2*RoundTime+Interval and declared dead SuspectTimeout
+// later, 12 seconds with the defaults however many nodes there are.
+//
+// Maelstrom partitions heal, so dead is not final here: members that are
+// dead keep being pinged, and a member that learns it is suspected or dead
+// refutes it by announcing itself alive with a higher incarnation.
+package swim
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+)
+
+// State is what a node believes about a member.
+type State int
+
+const (
+	Alive State = iota
+	Suspect
+	Dead
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// update announces the state of a member at an incarnation.
+type update struct {
+	Node        string `json:"node"`
+	State       State  `json:"state"`
+	Incarnation int    `json:"incarnation"`
+}
+
+// ping probes the receiver directly.
+type ping struct {
+	Updates []update `json:"updates"`
+}
+
+type ack struct {
+	Updates []update `json:"updates"`
+}
+
+// pingReq asks the receiver to ping Target and answer once it acks.
+type pingReq struct {
+	Target  string   `json:"target" required:"true"`
+	Updates []update `json:"updates"`
+}
+
+type pingReqOk struct {
+	Updates []update `json:"updates"`
+}
+
+func (ping) Type() string      { return "swim_ping" }
+func (ack) Type() string       { return "swim_ack" }
+func (pingReq) Type() string   { return "swim_ping_req" }
+func (pingReqOk) Type() string { return "swim_ping_req_ok" }
+
+// Options configure a Membership. Zero values select the defaults.
+type Options struct {
+	// Interval is the protocol period. Defaults to 1 second.
+	Interval time.Duration
+
+	// RoundTime is the time in which every member is probed once. Every
+	// interval enough members are probed at once to keep to it, so that
+	// failures are detected as fast in large clusters as in small ones.
+	// Defaults to 4 seconds.
+	RoundTime time.Duration
+
+	// Timeout bounds every ping. The indirect pings must be answered within
+	// the rest of the interval. Defaults to 300 milliseconds.
+	Timeout time.Duration
+
+	// IndirectProbes is the number of members asked to ping a member that
+	// did not answer. Defaults to 2.
+	IndirectProbes int
+
+	// SuspectTimeout is how long a member stays suspected before it is
+	// declared dead. Defaults to 3 seconds.
+	SuspectTimeout time.Duration
+
+	// Seed is mixed with the node id to seed the probe order.
+	Seed int64
+}
+
+// Membership is a node's view of which members are alive.
+type Membership struct {
+	n    *maelstrom.Node
+	clk  clock.Clock
+	opts Options
+
+	ticker clock.Ticker
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	mu          sync.Mutex
+	members     map[string]*member
+	incarnation int
+	queue       []*broadcast
+	order       []string
+	rand        *rand.Rand
+}
+
+type member struct {
+	state       State
+	incarnation int
+	suspected   time.Time
+}
+
+// broadcast is an update waiting to be piggybacked.
+type broadcast struct {
+	update
+	sent int
+}
+
+// maxPiggyback bounds the number of updates carried by one message.
+const maxPiggyback = 8
+
+// Start registers the SWIM handlers on n and starts probing. It must be
+// called before n.Run.
+func Start(n *maelstrom.Node, clk clock.Clock, opts Options) *Membership {
+	if opts.Interval == 0 {
+		opts.Interval = time.Second
+	}
+	if opts.RoundTime == 0 {
+		opts.RoundTime = 4 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 300 * time.Millisecond
+	}
+	if opts.IndirectProbes == 0 {
+		opts.IndirectProbes = 2
+	}
+	if opts.SuspectTimeout == 0 {
+		opts.SuspectTimeout = 3 * time.Second
+	}
+	m := &Membership{
+		n:       n,
+		clk:     clk,
+		opts:    opts,
+		ticker:  clk.NewTicker(opts.Interval),
+		done:    make(chan struct{}),
+		members: make(map[string]*member),
+	}
+
+	proto.Handle(n, func(msg maelstrom.Message, req ping) error {
+		m.merge(req.Updates)
+		return proto.Reply(n, msg, ack{m.piggyback(msg.Src)})
+	})
+	proto.Handle(n, func(msg maelstrom.Message, req pingReq) error {
+		m.merge(req.Updates)
+		ctx, cancel := clk.WithTimeout(context.Background(), opts.Timeout)
+		defer cancel()
+		if !m.ping(ctx, req.Target, m.piggyback(req.Target)) {
+			return proto.Unavailable("%s did not answer", req.Target)
+		}
+		return proto.Reply(n, msg, pingReqOk{m.piggyback(msg.Src)})
+	})
+
+	m.wg.Add(1)
+	go m.run()
+	return m
+}
+
+// Stop stops probing and waits for the current probe to finish.
+func (m *Membership) Stop() {
+	m.ticker.Stop()
+	close(m.done)
+	m.wg.Wait()
+}
+
+// State returns what the node believes about node. Nodes it has not heard
+// of, including itself, are alive.
+func (m *Membership) State(node string) State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mem, ok := m.members[node]; ok {
+		return mem.state
+	}
+	return Alive
+}
+
+// Reachable reports whether node is believed alive, so that callers can
+// route around suspected and dead members.
+func (m *Membership) Reachable(node string) bool {
+	return m.State(node) == Alive
+}
+
+// Filter returns the nodes that are reachable.
+func (m *Membership) Filter(nodes []string) []string {
+	var reachable []string
+	for _, node := range nodes {
+		if m.Reachable(node) {
+			reachable = append(reachable, node)
+		}
+	}
+	return reachable
+}
+
+func (m *Membership) run() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.ticker.C():
+		}
+		if !proto.Initialized(m.n) {
+			continue
+		}
+		m.expire()
+
+		// The probes' timeouts and updates are picked before going
+		// concurrent, so that they do not depend on scheduling.
+		var wg sync.WaitGroup
+		for _, target := range m.next() {
+			ctx, cancel := m.clk.WithTimeout(context.Background(), m.opts.Timeout)
+			updates := m.piggyback(target)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer cancel()
+				m.probe(ctx, target, updates)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// probe pings target directly with updates until ctx is done and then
+// through IndirectProbes other members, and suspects it if none of the pings
+// is answered.
+func (m *Membership) probe(ctx context.Context, target string, updates []update) {
+	if m.ping(ctx, target, updates) {
+		return
+	}
+
+	wait := m.opts.Interval - m.opts.Timeout
+	ctx, cancel := m.clk.WithTimeout(context.Background(), wait)
+	defer cancel()
+	acked := make(chan struct{}, m.opts.IndirectProbes)
+	helpers := m.helpers(target)
+	for _, helper := range helpers {
+		// Updates are picked before going concurrent, so that which helper
+		// carries which update does not depend on scheduling.
+		req := pingReq{Target: target, Updates: m.piggyback(helper)}
+		go func() {
+			if resp, err := proto.RPC[pingReqOk](ctx, m.n, helper, req); err == nil {
+				m.merge(resp.Updates)
+				acked <- struct{}{}
+			}
+		}()
+	}
+	for range helpers {
+		select {
+		case <-acked:
+			return
+		case <-ctx.Done():
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem := m.member(target)
+	if mem.state == Alive {
+		m.apply(update{Node: target, State: Suspect, Incarnation: mem.incarnation})
+	}
+}
+
+// ping reports whether target acked a ping carrying updates before ctx is
+// done.
+func (m *Membership) ping(ctx context.Context, target string, updates []update) bool {
+	resp, err := proto.RPC[ack](ctx, m.n, target, ping{updates})
+	if err != nil {
+		return false
+	}
+	m.merge(resp.Updates)
+	return true
+}
+
+// next returns the members to probe this interval, walking the members in a
+// random order that is reshuffled after every round.
+func (m *Membership) next() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.order) == 0 {
+		for _, node := range m.n.NodeIDs() {
+			if node != m.n.ID() {
+				m.order = append(m.order, node)
+			}
+		}
+		if m.rand == nil {
+			h := fnv.New64a()
+			h.Write([]byte(m.n.ID()))
+			m.rand = rand.New(rand.NewSource(int64(h.Sum64()) ^ m.opts.Seed))
+		}
+		m.rand.Shuffle(len(m.order), func(i, j int) {
+			m.order[i], m.order[j] = m.order[j], m.order[i]
+		})
+	}
+	// Probing k members every interval gets through the others within
+	// RoundTime.
+	members := time.Duration(len(m.n.NodeIDs()) - 1)
+	round := m.opts.RoundTime
+	k := max(1, int((members*m.opts.Interval+round-1)/round))
+	targets := m.order[:min(k, len(m.order))]
+	m.order = m.order[len(targets):]
+	return targets
+}
+
+// helpers picks the members asked to ping target, preferring alive ones.
+func (m *Membership) helpers(target string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var candidates []string
+	for _, node := range m.n.NodeIDs() {
+		if node != m.n.ID() && node != target && m.member(node).state == Alive {
+			candidates = append(candidates, node)
+		}
+	}
+	m.rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	return candidates[:min(len(candidates), m.opts.IndirectProbes)]
+}
+
+// expire declares dead the members suspected for longer than
+// SuspectTimeout.
+func (m *Membership) expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clk.Now()
+	// Walking the members in a fixed order keeps the updates queued in
+	// the same order on every run.
+	for _, node := range m.n.NodeIDs() {
+		mem, ok := m.members[node]
+		if !ok || mem.state != Suspect {
+			continue
+		}
+		if now.Sub(mem.suspected) >= m.opts.SuspectTimeout {
+			m.apply(update{Node: node, State: Dead, Incarnation: mem.incarnation})
+		}
+	}
+}
+
+func (m *Membership) merge(updates []update) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range updates {
+		m.apply(u)
+	}
+}
+
+// apply records u if it is newer than what the node knows and queues it for
+// dissemination. An update that suspects or declares the node itself dead
+// is refuted instead. m.mu must be held.
+func (m *Membership) apply(u update) {
+	if u.Node == m.n.ID() {
+		if u.State != Alive && u.Incarnation >= m.incarnation {
+			m.incarnation = u.Incarnation + 1
+			m.enqueue(update{Node: u.Node, State: Alive, Incarnation: m.incarnation})
+		}
+		return
+	}
+
+	mem := m.member(u.Node)
+	if !overrides(u, mem) {
+		return
+	}
+	if u.State == Suspect && mem.state != Suspect {
+		mem.suspected = m.clk.Now()
+	}
+	mem.state, mem.incarnation = u.State, u.Incarnation
+	m.enqueue(u)
+}
+
+// overrides reports whether u replaces what is known about a member: a
+// higher incarnation always does, and at the same incarnation suspect
+// overrides alive and dead overrides both.
+func overrides(u update, mem *member) bool {
+	if u.Incarnation != mem.incarnation {
+		return u.Incarnation > mem.incarnation
+	}
+	return u.State > mem.state
+}
+
+// member returns the entry of node, creating it alive. m.mu must be held.
+func (m *Membership) member(node string) *member {
+	mem, ok := m.members[node]
+	if !ok {
+		mem = &member{}
+		m.members[node] = mem
+	}
+	return mem
+}
+
+// enqueue queues u for dissemination, replacing any older update about the
+// same member. m.mu must be held.
+func (m *Membership) enqueue(u update) {
+	m.queue = slices.DeleteFunc(m.queue, func(b *broadcast) bool {
+		return b.Node == u.Node
+	})
+	m.queue = append(m.queue, &broadcast{update: u})
+}
+
+// piggyback returns the updates to send to dest: the least sent ones,
+// preceded by what the node believes about dest when it is not alive, so
+// that dest can refute it. Every update is sent about 3 log n times.
+func (m *Membership) piggyback(dest string) []update {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var updates []update
+	if mem, ok := m.members[dest]; ok && mem.state != Alive {
+		u := update{Node: dest, State: mem.state, Incarnation: mem.incarnation}
+		updates = append(updates, u)
+	}
+
+	slices.SortStableFunc(m.queue, func(a, b *broadcast) int {
+		return a.sent - b.sent
+	})
+	nodes := float64(len(m.n.NodeIDs()))
+	limit := 3 * int(math.Ceil(math.Log2(nodes+1)))
+	for _, b := range m.queue {
+		if len(updates) == maxPiggyback {
+			break
+		}
+		updates = append(updates, b.update)
+		b.sent++
+	}
+	m.queue = slices.DeleteFunc(m.queue, func(b *broadcast) bool {
+		return b.sent >= limit
+	})
+	return updates
+}
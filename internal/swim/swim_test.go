@@ -0,0 +1,65 @@
+package swim
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+)
+
+func TestDetectionBound(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulates 25 nodes")
+	}
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+		t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	}
+
+	net := sim.New(sim.Config{Latency: 10 * time.Millisecond, Seed: 1, Deterministic: true})
+	var mu sync.Mutex
+	members := make(map[string]*Membership)
+	net.AddNodes(25, func(n *maelstrom.Node) error {
+		m := Start(n, net.Clock(), Options{})
+		defer m.Stop()
+		mu.Lock()
+		members[n.ID()] = m
+		mu.Unlock()
+		return proto.Run(n)
+	})
+	defer net.Close()
+	if err := net.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	net.Sleep(5 * time.Second)
+
+	// With the default options a failed node is declared dead within
+	// 2*RoundTime+Interval+SuspectTimeout.
+	const bound = 12 * time.Second
+	net.Isolate("n0")
+	for elapsed := time.Duration(0); ; elapsed += time.Second {
+		dead := true
+		mu.Lock()
+		for id, m := range members {
+			if id != "n0" && m.State("n0") != Dead {
+				dead = false
+			}
+		}
+		mu.Unlock()
+		if dead {
+			t.Logf("n0 declared dead everywhere after %v", elapsed)
+			return
+		}
+		if elapsed >= bound {
+			t.Fatalf("n0 not declared dead everywhere after %v", bound)
+		}
+		net.Sleep(time.Second)
+	}
+}
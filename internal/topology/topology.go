@@ -0,0 +1,316 @@
+// Package topology computes the neighbours of broadcast nodes. A strategy is
+// named by a string such as "tree:4" and is selected with the -topology flag
+// or the GLOMERS_TOPOLOGY environment variable.
+package topology
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvVar is the environment variable that selects the strategy when the
+// -topology flag is not given.
+const EnvVar = "GLOMERS_TOPOLOGY"
+
+// Usage describes the strategy names accepted by Parse.
+const Usage = "maelstrom, tree[:k], ring, grid, random[:k], mesh or star"
+
+// Strategy returns the neighbours of every node in nodes. given is the
+// topology sent by Maelstrom. Neighbours are symmetric: if a is a neighbour
+// of b, b is a neighbour of a, except for the maelstrom strategy, which
+// returns given unchanged.
+type Strategy func(nodes []string, given map[string][]string) map[string][]string
+
+// FromFlags parses the -topology flag of the command line, which defaults to
+// GLOMERS_TOPOLOGY or, when that is unset, to def, and returns the strategy
+// it names.
+func FromFlags(def string) (Strategy, error) {
+	if env := os.Getenv(EnvVar); env != "" {
+		def = env
+	}
+	name := flag.String("topology", def, "neighbour `strategy`: "+Usage)
+	flag.Parse()
+	return Parse(*name)
+}
+
+// Parse returns the strategy named s. The k of tree and random defaults to
+// 4.
+func Parse(s string) (Strategy, error) {
+	name, arg, hasArg := strings.Cut(s, ":")
+	k := 4
+	if hasArg {
+		if name != "tree" && name != "random" {
+			return nil, fmt.Errorf("topology %q does not take an argument", s)
+		}
+		var err error
+		if k, err = strconv.Atoi(arg); err != nil || k < 1 {
+			return nil, fmt.Errorf("topology %q: invalid k %q", s, arg)
+		}
+	}
+
+	switch name {
+	case "maelstrom":
+		return Maelstrom, nil
+	case "tree":
+		return func(nodes []string, _ map[string][]string) map[string][]string { return Tree(nodes, k) }, nil
+	case "ring":
+		return ignoreGiven(Ring), nil
+	case "grid":
+		return ignoreGiven(Grid), nil
+	case "random":
+		return func(nodes []string, _ map[string][]string) map[string][]string { return Random(nodes, k) }, nil
+	case "mesh":
+		return ignoreGiven(Mesh), nil
+	case "star":
+		return ignoreGiven(Star), nil
+	}
+	return nil, fmt.Errorf("unknown topology %q, want %s", s, Usage)
+}
+
+func ignoreGiven(f func(nodes []string) map[string][]string) Strategy {
+	return func(nodes []string, _ map[string][]string) map[string][]string { return f(nodes) }
+}
+
+// Maelstrom returns the topology sent by Maelstrom.
+func Maelstrom(_ []string, given map[string][]string) map[string][]string {
+	return given
+}
+
+// Tree arranges nodes in a k-ary tree in the order of nodes, rooted at the
+// first one.
+func Tree(nodes []string, k int) map[string][]string {
+	g := newGraph(nodes)
+	for i := 1; i < len(nodes); i++ {
+		g.connect(i, (i-1)/k)
+	}
+	return g.neighbors()
+}
+
+// Ring connects every node to the nodes before and after it.
+func Ring(nodes []string) map[string][]string {
+	g := newGraph(nodes)
+	for i := range nodes {
+		g.connect(i, (i+1)%len(nodes))
+	}
+	return g.neighbors()
+}
+
+// Grid arranges nodes in a square grid, each connected to its horizontal
+// and vertical neighbours, like Maelstrom's default broadcast topology.
+func Grid(nodes []string) map[string][]string {
+	g := newGraph(nodes)
+	width := int(math.Ceil(math.Sqrt(float64(len(nodes)))))
+	for i := range nodes {
+		if i%width < width-1 && i+1 < len(nodes) {
+			g.connect(i, i+1)
+		}
+		if i+width < len(nodes) {
+			g.connect(i, i+width)
+		}
+	}
+	return g.neighbors()
+}
+
+// Random connects every node to k others at random, rounding k up to an
+// even number. The graph is the union of k/2 random Hamiltonian cycles, so
+// it is connected, and it is seeded with a constant, so that every node
+// computes the same one.
+func Random(nodes []string, k int) map[string][]string {
+	rng := rand.New(rand.NewSource(1))
+	var g *graph
+	for range 100 {
+		g = newGraph(nodes)
+		ok := true
+		for range (k + 1) / 2 {
+			perm := rng.Perm(len(nodes))
+			for i, a := range perm {
+				ok = g.connect(a, perm[(i+1)%len(perm)]) && ok
+			}
+		}
+		if ok {
+			break
+		}
+	}
+	return g.neighbors()
+}
+
+// Mesh connects every node to every other node.
+func Mesh(nodes []string) map[string][]string {
+	g := newGraph(nodes)
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			g.connect(i, j)
+		}
+	}
+	return g.neighbors()
+}
+
+// Star connects the first node to every other node.
+func Star(nodes []string) map[string][]string {
+	g := newGraph(nodes)
+	for i := 1; i < len(nodes); i++ {
+		g.connect(0, i)
+	}
+	return g.neighbors()
+}
+
+// Latency arranges nodes in a tree that follows the round trip times given by
+// rtt, which must be symmetric. The root is the node with the least total
+// round trip time to the others. Every other node is then attached, in turn,
+// to the node through which it is closest to the root, among those with
+// fewer than k children. Ties are broken by the order of nodes, so that
+// nodes agreeing on rtt compute the same tree.
+func Latency(nodes []string, rtt func(a, b string) time.Duration, k int) map[string][]string {
+	g := newGraph(nodes)
+	if len(nodes) == 0 {
+		return g.neighbors()
+	}
+
+	root, best := 0, time.Duration(math.MaxInt64)
+	for i, a := range nodes {
+		var total time.Duration
+		for j, b := range nodes {
+			if i != j {
+				total += rtt(a, b)
+			}
+		}
+		if total < best {
+			root, best = i, total
+		}
+	}
+
+	dist := make([]time.Duration, len(nodes))
+	children := make([]int, len(nodes))
+	attached := make([]bool, len(nodes))
+	attached[root] = true
+	for range len(nodes) - 1 {
+		parent, child := -1, -1
+		var closest time.Duration
+		for u := range nodes {
+			if !attached[u] || children[u] == k {
+				continue
+			}
+			for v := range nodes {
+				if attached[v] {
+					continue
+				}
+				if d := dist[u] + rtt(nodes[u], nodes[v]); parent == -1 || d < closest {
+					parent, child, closest = u, v, d
+				}
+			}
+		}
+		attached[child] = true
+		dist[child] = closest
+		children[parent]++
+		g.connect(parent, child)
+	}
+	return g.neighbors()
+}
+
+// Heal reconnects the live nodes of neighbors when nodes that live reports
+// as down split them apart. Parents and ancestors are taken with neighbors
+// hung from the first node of nodes, even if it was built around another
+// root, as Latency trees are, and every live node whose parent is down is
+// attached to its closest live ancestor. If the root is down too, the first
+// such node without a live ancestor stands in for it and the others are
+// attached to it. Once every node is live again, neighbors is returned
+// unchanged. Nodes whose live functions disagree heal differently, so an
+// edge may be known to one end only. Heal is meant for trees: in graphs
+// with cycles it may add edges that were not needed.
+func Heal(nodes []string, neighbors map[string][]string, live func(string) bool) map[string][]string {
+	if len(nodes) == 0 {
+		return neighbors
+	}
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node] = i
+	}
+
+	parent := make([]int, len(nodes))
+	visited := make([]bool, len(nodes))
+	parent[0], visited[0] = -1, true
+	order := []int{0}
+	for i := 0; i < len(order); i++ {
+		u := order[i]
+		for _, nbor := range neighbors[nodes[u]] {
+			if v, ok := index[nbor]; ok && !visited[v] {
+				parent[v], visited[v] = u, true
+				order = append(order, v)
+			}
+		}
+	}
+
+	healed, cloned := neighbors, false
+	standIn := -1
+	for _, v := range order {
+		p := parent[v]
+		if p == -1 || !live(nodes[v]) || live(nodes[p]) {
+			continue
+		}
+		for p != -1 && !live(nodes[p]) {
+			p = parent[p]
+		}
+		if p == -1 {
+			if standIn == -1 {
+				standIn = v
+				continue
+			}
+			p = standIn
+		}
+		if !cloned {
+			healed, cloned = maps.Clone(neighbors), true
+		}
+		a, b := nodes[p], nodes[v]
+		healed[a] = append(slices.Clip(healed[a]), b)
+		healed[b] = append(slices.Clip(healed[b]), a)
+	}
+	return healed
+}
+
+// graph is an undirected graph over the indices of nodes.
+type graph struct {
+	nodes []string
+	edges []map[int]bool
+	order [][]int
+}
+
+func newGraph(nodes []string) *graph {
+	g := &graph{nodes: nodes, edges: make([]map[int]bool, len(nodes)), order: make([][]int, len(nodes))}
+	for i := range nodes {
+		g.edges[i] = make(map[int]bool)
+	}
+	return g
+}
+
+// connect adds an edge between a and b. It reports false if the edge is a
+// loop or already exists, in which case the graph is left unchanged.
+func (g *graph) connect(a, b int) bool {
+	if a == b || g.edges[a][b] {
+		return false
+	}
+	g.edges[a][b], g.edges[b][a] = true, true
+	g.order[a] = append(g.order[a], b)
+	g.order[b] = append(g.order[b], a)
+	return true
+}
+
+// neighbors returns the neighbours of every node, in the order their edges
+// were added.
+func (g *graph) neighbors() map[string][]string {
+	m := make(map[string][]string, len(g.nodes))
+	for i, node := range g.nodes {
+		m[node] = make([]string, 0, len(g.order[i]))
+		for _, j := range g.order[i] {
+			m[node] = append(m[node], g.nodes[j])
+		}
+	}
+	return m
+}
@@ -0,0 +1,100 @@
+// Package vclock implements vector clocks over node ids.
+package vclock
+
+// Clock maps node ids to the number of events seen from them. Missing
+// entries are zero. The zero value is not ready to use; make one with New.
+type Clock map[string]int
+
+// Order is how two clocks relate.
+type Order int
+
+const (
+	Equal Order = iota
+	Before
+	After
+	Concurrent
+)
+
+func (o Order) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	case Concurrent:
+		return "concurrent"
+	}
+	return "unknown"
+}
+
+// New returns an empty clock.
+func New() Clock {
+	return make(Clock)
+}
+
+// Clone returns a copy of c.
+func (c Clock) Clone() Clock {
+	clone := make(Clock, len(c))
+	for node, n := range c {
+		clone[node] = n
+	}
+	return clone
+}
+
+// Tick counts an event on node and returns its new count.
+func (c Clock) Tick(node string) int {
+	c[node]++
+	return c[node]
+}
+
+// Merge raises every entry of c to at least the one of other.
+func (c Clock) Merge(other Clock) {
+	for node, n := range other {
+		c[node] = max(c[node], n)
+	}
+}
+
+// Compare returns whether c happened before, after or concurrently with
+// other.
+func (c Clock) Compare(other Clock) Order {
+	less, greater := false, false
+	for node, n := range c {
+		if n < other[node] {
+			less = true
+		} else if n > other[node] {
+			greater = true
+		}
+	}
+	for node, n := range other {
+		if _, ok := c[node]; !ok && n > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	}
+	return Equal
+}
+
+// Deliverable reports whether a message sent by from with clock msg can be
+// delivered at a node that has delivered c: it must be the next message from
+// from, and every message it depends on from other nodes must have been
+// delivered.
+func (c Clock) Deliverable(from string, msg Clock) bool {
+	if msg[from] != c[from]+1 {
+		return false
+	}
+	for node, n := range msg {
+		if node != from && n > c[node] {
+			return false
+		}
+	}
+	return true
+}
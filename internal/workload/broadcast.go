@@ -0,0 +1,84 @@
+package workload
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/toxeeec/gossip-glomers/internal/checker"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+	"github.com/toxeeec/gossip-glomers/internal/topology"
+)
+
+type broadcast struct {
+	rec        *checker.Recorder[checker.BroadcastValue]
+	next       atomic.Int64
+	totalOrder bool
+
+	// byzantine nodes are not read from, and broadcasts sent to them are
+	// indeterminate, since they may ignore them or deliver other values.
+	byzantine map[string]bool
+}
+
+func newBroadcast(clk clock.Clock, totalOrder bool, byzantine []string) *broadcast {
+	w := &broadcast{
+		rec:        checker.NewRecorder[checker.BroadcastValue](clk),
+		totalOrder: totalOrder,
+		byzantine:  make(map[string]bool),
+	}
+	for _, node := range byzantine {
+		w.byzantine[node] = true
+	}
+	return w
+}
+
+func (w *broadcast) setup(ctx context.Context, c *sim.Client, nodes []string) error {
+	grid := topology.Grid(nodes)
+	for _, node := range nodes {
+		if err := c.Topology(ctx, node, grid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *broadcast) op(ctx context.Context, c *sim.Client, node string, rng *rand.Rand) {
+	if rng.Intn(2) == 0 {
+		if !w.byzantine[node] {
+			w.read(ctx, c, node, "read")
+		}
+		return
+	}
+	msg := int(w.next.Add(1))
+	done := w.rec.Invoke(c.ID(), "broadcast", checker.BroadcastValue{Message: msg})
+	res := outcome(c.Broadcast(ctx, node, msg))
+	if w.byzantine[node] {
+		res = checker.Info
+	}
+	done(res, checker.BroadcastValue{Message: msg})
+}
+
+func (w *broadcast) final(ctx context.Context, c *sim.Client, nodes []string) {
+	for _, node := range nodes {
+		if !w.byzantine[node] {
+			w.read(ctx, c, node, checker.FinalRead)
+		}
+	}
+}
+
+func (w *broadcast) read(ctx context.Context, c *sim.Client, node, f string) {
+	done := w.rec.Invoke(c.ID(), f, checker.BroadcastValue{})
+	msgs, err := c.ReadMessages(ctx, node)
+	done(outcome(err), checker.BroadcastValue{Messages: msgs})
+}
+
+func (w *broadcast) check() (checker.Result, error) {
+	if w.totalOrder {
+		return checker.CheckTotalOrder(w.rec.History()), nil
+	}
+	if len(w.byzantine) > 0 {
+		return checker.CheckAgreement(w.rec.History()), nil
+	}
+	return checker.CheckBroadcast(w.rec.History()), nil
+}
@@ -0,0 +1,54 @@
+package workload
+
+import (
+	"context"
+	"math/rand"
+
+	"github.com/toxeeec/gossip-glomers/internal/checker"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+)
+
+type counter struct {
+	rec *checker.Recorder[int]
+
+	// pn makes deltas negative as often as positive, as in the pn-counter
+	// workload.
+	pn bool
+}
+
+func newCounter(clk clock.Clock, pn bool) *counter {
+	return &counter{rec: checker.NewRecorder[int](clk), pn: pn}
+}
+
+func (w *counter) setup(context.Context, *sim.Client, []string) error { return nil }
+
+func (w *counter) op(ctx context.Context, c *sim.Client, node string, rng *rand.Rand) {
+	if rng.Intn(2) == 0 {
+		w.read(ctx, c, node, "read")
+		return
+	}
+	delta := rng.Intn(5)
+	if w.pn {
+		delta = rng.Intn(9) - 4
+	}
+	done := w.rec.Invoke(c.ID(), "add", delta)
+	err := c.Add(ctx, node, delta)
+	done(outcome(err), delta)
+}
+
+func (w *counter) final(ctx context.Context, c *sim.Client, nodes []string) {
+	for _, node := range nodes {
+		w.read(ctx, c, node, checker.FinalRead)
+	}
+}
+
+func (w *counter) read(ctx context.Context, c *sim.Client, node, f string) {
+	done := w.rec.Invoke(c.ID(), f, 0)
+	value, err := c.ReadValue(ctx, node)
+	done(outcome(err), value)
+}
+
+func (w *counter) check() (checker.Result, error) {
+	return checker.CheckCounter(w.rec.History()), nil
+}
@@ -0,0 +1,34 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/toxeeec/gossip-glomers/internal/checker"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+)
+
+type echo struct {
+	rec *checker.Recorder[string]
+}
+
+func newEcho(clk clock.Clock) *echo {
+	return &echo{rec: checker.NewRecorder[string](clk)}
+}
+
+func (w *echo) setup(context.Context, *sim.Client, []string) error { return nil }
+
+func (w *echo) op(ctx context.Context, c *sim.Client, node string, rng *rand.Rand) {
+	s := fmt.Sprintf("Please echo %d", rng.Intn(128))
+	done := w.rec.Invoke(c.ID(), "echo", s)
+	resp, err := c.Echo(ctx, node, s)
+	done(outcome(err), resp)
+}
+
+func (w *echo) final(context.Context, *sim.Client, []string) {}
+
+func (w *echo) check() (checker.Result, error) {
+	return checker.CheckEcho(w.rec.History()), nil
+}
@@ -0,0 +1,111 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/toxeeec/gossip-glomers/internal/checker"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+)
+
+const kafkaKeys = 8
+
+type kafka struct {
+	rec  *checker.Recorder[checker.KafkaValue]
+	next atomic.Int64
+
+	mu sync.Mutex
+	// polled holds, for each client, the offset after the last message it
+	// polled from each key.
+	polled map[string]map[string]int
+}
+
+func newKafka(clk clock.Clock) *kafka {
+	return &kafka{
+		rec:    checker.NewRecorder[checker.KafkaValue](clk),
+		polled: make(map[string]map[string]int),
+	}
+}
+
+func (w *kafka) setup(context.Context, *sim.Client, []string) error { return nil }
+
+func (w *kafka) op(ctx context.Context, c *sim.Client, node string, rng *rand.Rand) {
+	switch n := rng.Intn(10); {
+	case n < 4:
+		w.send(ctx, c, node, fmt.Sprint(rng.Intn(kafkaKeys)))
+	case n < 7:
+		w.poll(ctx, c, node)
+	case n < 9:
+		w.commit(ctx, c, node)
+	default:
+		w.list(ctx, c, node)
+	}
+}
+
+func (w *kafka) send(ctx context.Context, c *sim.Client, node, key string) {
+	msg := int(w.next.Add(1))
+	done := w.rec.Invoke(c.ID(), "send", checker.KafkaValue{Key: key, Msg: msg})
+	offset, err := c.Send(ctx, node, key, msg)
+	done(outcome(err), checker.KafkaValue{Key: key, Msg: msg, Offset: offset})
+}
+
+func (w *kafka) poll(ctx context.Context, c *sim.Client, node string) {
+	offsets := w.offsets(c.ID())
+	done := w.rec.Invoke(c.ID(), "poll", checker.KafkaValue{Offsets: offsets})
+	msgs, err := c.Poll(ctx, node, offsets)
+	done(outcome(err), checker.KafkaValue{Offsets: offsets, Msgs: msgs})
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, ms := range msgs {
+		if len(ms) > 0 {
+			w.polled[c.ID()][key] = max(w.polled[c.ID()][key], ms[len(ms)-1][0]+1)
+		}
+	}
+}
+
+func (w *kafka) commit(ctx context.Context, c *sim.Client, node string) {
+	offsets := w.offsets(c.ID())
+	done := w.rec.Invoke(c.ID(), "commit_offsets", checker.KafkaValue{Offsets: offsets})
+	err := c.CommitOffsets(ctx, node, offsets)
+	done(outcome(err), checker.KafkaValue{Offsets: offsets})
+}
+
+func (w *kafka) list(ctx context.Context, c *sim.Client, node string) {
+	keys := make([]string, kafkaKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	done := w.rec.Invoke(c.ID(), "list_committed_offsets", checker.KafkaValue{Keys: keys})
+	offsets, err := c.ListCommittedOffsets(ctx, node, keys)
+	done(outcome(err), checker.KafkaValue{Keys: keys, Offsets: offsets})
+}
+
+// offsets returns the offsets the client polls from and commits: the offset
+// after the last message it polled from each key.
+func (w *kafka) offsets(client string) map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.polled[client] == nil {
+		w.polled[client] = make(map[string]int)
+	}
+	offsets := make(map[string]int, kafkaKeys)
+	for i := range kafkaKeys {
+		key := fmt.Sprint(i)
+		offsets[key] = w.polled[client][key]
+	}
+	return offsets
+}
+
+func (w *kafka) final(context.Context, *sim.Client, []string) {}
+
+func (w *kafka) check() (checker.Result, error) {
+	return checker.CheckKafka(w.rec.History()), nil
+}
@@ -0,0 +1,56 @@
+package workload
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/toxeeec/gossip-glomers/internal/checker"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/proto"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+)
+
+const txnKeys = 10
+
+type txn struct {
+	rec   *checker.Recorder[checker.TxnValue]
+	model string
+	next  atomic.Int64
+}
+
+func newTxn(clk clock.Clock, model string) *txn {
+	if model == "" {
+		model = "read-uncommitted"
+	}
+	return &txn{rec: checker.NewRecorder[checker.TxnValue](clk), model: model}
+}
+
+func (w *txn) setup(context.Context, *sim.Client, []string) error { return nil }
+
+func (w *txn) op(ctx context.Context, c *sim.Client, node string, rng *rand.Rand) {
+	ops := make(checker.TxnValue, 1+rng.Intn(4))
+	for i := range ops {
+		key := rng.Intn(txnKeys)
+		if rng.Intn(2) == 0 {
+			ops[i] = proto.Op{Name: "r", Key: key}
+		} else {
+			v := int(w.next.Add(1))
+			ops[i] = proto.Op{Name: "w", Key: key, Value: &v}
+		}
+	}
+
+	done := w.rec.Invoke(c.ID(), "txn", ops)
+	completed, err := c.Txn(ctx, node, ops)
+	if err != nil {
+		done(outcome(err), ops)
+		return
+	}
+	done(checker.Ok, completed)
+}
+
+func (w *txn) final(context.Context, *sim.Client, []string) {}
+
+func (w *txn) check() (checker.Result, error) {
+	return checker.CheckTxn(w.rec.History(), w.model)
+}
@@ -0,0 +1,32 @@
+package workload
+
+import (
+	"context"
+	"math/rand"
+
+	"github.com/toxeeec/gossip-glomers/internal/checker"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+)
+
+type uniqueIDs struct {
+	rec *checker.Recorder[any]
+}
+
+func newUniqueIDs(clk clock.Clock) *uniqueIDs {
+	return &uniqueIDs{rec: checker.NewRecorder[any](clk)}
+}
+
+func (w *uniqueIDs) setup(context.Context, *sim.Client, []string) error { return nil }
+
+func (w *uniqueIDs) op(ctx context.Context, c *sim.Client, node string, _ *rand.Rand) {
+	done := w.rec.Invoke(c.ID(), "generate", nil)
+	id, err := c.Generate(ctx, node)
+	done(outcome(err), id)
+}
+
+func (w *uniqueIDs) final(context.Context, *sim.Client, []string) {}
+
+func (w *uniqueIDs) check() (checker.Result, error) {
+	return checker.CheckUniqueIDs(w.rec.History()), nil
+}
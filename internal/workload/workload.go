@@ -0,0 +1,316 @@
+// Package workload drives the Maelstrom workloads against a simulated network
+// and checks the resulting histories, so that challenges can be tested
+// without Maelstrom.
+package workload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"github.com/toxeeec/gossip-glomers/internal/checker"
+	"github.com/toxeeec/gossip-glomers/internal/clock"
+	"github.com/toxeeec/gossip-glomers/internal/kvservice"
+	"github.com/toxeeec/gossip-glomers/internal/sim"
+)
+
+// Options mirror the Maelstrom test options that the workloads understand.
+type Options struct {
+	Workload    string
+	TimeLimit   time.Duration
+	Rate        float64
+	Concurrency int
+
+	// Partition enables a nemesis that repeatedly splits the nodes into two
+	// random halves and heals them.
+	Partition bool
+
+	// ConsistencyModel is checked by the txn-rw-register workload.
+	ConsistencyModel string
+
+	// TotalOrder makes the broadcast workload also check that all reads list
+	// the messages in the same order.
+	TotalOrder bool
+
+	// Byzantine lists nodes that equivocate: they negate the message field
+	// of what they send to half of the other nodes. Only the broadcast
+	// workload supports them.
+	Byzantine []string
+
+	// Recovery is how long to wait after healing before the final reads.
+	// Defaults to 5 seconds.
+	Recovery time.Duration
+
+	// Timeout bounds each client request. Defaults to 1 second.
+	Timeout time.Duration
+
+	Seed int64
+}
+
+// Report is the outcome of a workload run.
+type Report struct {
+	Result checker.Result
+	Ops    int
+	Stats  sim.Stats
+}
+
+// MsgsPerOp returns the number of messages between nodes per client
+// operation.
+func (r Report) MsgsPerOp() float64 {
+	if r.Ops == 0 {
+		return 0
+	}
+	return float64(r.Stats.ServerMsgs) / float64(r.Ops)
+}
+
+type workload interface {
+	// setup runs once before any operations.
+	setup(ctx context.Context, c *sim.Client, nodes []string) error
+	// op performs and records one random operation against node.
+	op(ctx context.Context, c *sim.Client, node string, rng *rand.Rand)
+	// final runs once after the network has healed and recovered.
+	final(ctx context.Context, c *sim.Client, nodes []string)
+	check() (checker.Result, error)
+}
+
+func newWorkload(opts Options, clk clock.Clock) (workload, error) {
+	switch opts.Workload {
+	case "echo":
+		return newEcho(clk), nil
+	case "unique-ids":
+		return newUniqueIDs(clk), nil
+	case "broadcast":
+		return newBroadcast(clk, opts.TotalOrder, opts.Byzantine), nil
+	case "g-counter":
+		return newCounter(clk, false), nil
+	case "pn-counter":
+		return newCounter(clk, true), nil
+	case "kafka":
+		return newKafka(clk), nil
+	case "txn-rw-register":
+		return newTxn(clk, opts.ConsistencyModel), nil
+	default:
+		return nil, fmt.Errorf("unsupported workload %q", opts.Workload)
+	}
+}
+
+// AddServices registers the key/value services that Maelstrom provides.
+func AddServices(net *sim.Network, seed int64) {
+	net.AddService(maelstrom.LinKV, kvservice.NewLinKV())
+	net.AddService(maelstrom.SeqKV, kvservice.NewSeqKV(0.1, seed))
+	net.AddService(maelstrom.LWWKV, kvservice.NewLWWKV(0.1, seed))
+}
+
+// Run starts the nodes of net, runs the workload against them and checks the
+// history. The nodes and services must already have been added.
+func Run(ctx context.Context, net *sim.Network, opts Options) (Report, error) {
+	if opts.Recovery == 0 {
+		opts.Recovery = 5 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = len(net.NodeIDs())
+	}
+	if opts.Rate == 0 {
+		opts.Rate = 5
+	}
+
+	w, err := newWorkload(opts, net.Clock())
+	if err != nil {
+		return Report{}, err
+	}
+	if err := net.Start(ctx); err != nil {
+		return Report{}, err
+	}
+	nodes := net.NodeIDs()
+	for _, id := range opts.Byzantine {
+		net.Byzantine(id, sim.Equivocate(nodes, "message"))
+	}
+	if err := w.setup(ctx, net.Client(), nodes); err != nil {
+		return Report{}, fmt.Errorf("setup: %w", err)
+	}
+
+	var ops int
+	if net.Deterministic() {
+		ops = runSerial(ctx, net, w, nodes, opts)
+	} else {
+		ops = runConcurrent(ctx, net, w, nodes, opts)
+	}
+
+	net.Heal()
+	if net.Deterministic() {
+		net.Sleep(opts.Recovery)
+	} else {
+		select {
+		case <-ctx.Done():
+			return Report{}, ctx.Err()
+		case <-time.After(opts.Recovery):
+		}
+	}
+	finalCtx, cancel := net.Clock().WithTimeout(ctx, opts.Timeout*time.Duration(len(nodes)+1))
+	defer cancel()
+	w.final(finalCtx, net.Client(), nodes)
+
+	res, err := w.check()
+	if err != nil {
+		return Report{}, err
+	}
+	return Report{Result: res, Ops: ops, Stats: net.Stats()}, nil
+}
+
+// runConcurrent runs opts.Concurrency clients in their own goroutines for
+// opts.TimeLimit, along with the partition nemesis, and returns the number of
+// operations they performed.
+func runConcurrent(ctx context.Context, net *sim.Network, w workload, nodes []string, opts Options) int {
+	runCtx, cancel := context.WithTimeout(ctx, opts.TimeLimit)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	if opts.Partition {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nem := &nemesis{net: net, nodes: nodes, rng: rand.New(rand.NewSource(opts.Seed))}
+			for {
+				select {
+				case <-runCtx.Done():
+					return
+				case <-time.After(nemesisInterval):
+				}
+				nem.toggle()
+			}
+		}()
+	}
+
+	interval := opInterval(opts)
+	var opsMu sync.Mutex
+	ops := 0
+	for i := range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := net.Client()
+			rng := rand.New(rand.NewSource(opts.Seed + int64(i) + 1))
+			for runCtx.Err() == nil {
+				opCtx, cancel := context.WithTimeout(runCtx, opts.Timeout)
+				w.op(opCtx, c, nodes[rng.Intn(len(nodes))], rng)
+				cancel()
+				opsMu.Lock()
+				ops++
+				opsMu.Unlock()
+
+				select {
+				case <-runCtx.Done():
+				case <-time.After(time.Duration(rng.Int63n(int64(2*interval) + 1))):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ops
+}
+
+// runSerial does what runConcurrent does on a deterministic network, where
+// only one goroutine may step the network: the clients and the nemesis take
+// turns in the order of their next action on the network's clock, and every
+// operation runs to completion before the next one starts.
+func runSerial(ctx context.Context, net *sim.Network, w workload, nodes []string, opts Options) int {
+	clk := net.Clock()
+	start := clk.Now()
+	deadline := start.Add(opts.TimeLimit)
+
+	type client struct {
+		c    *sim.Client
+		rng  *rand.Rand
+		next time.Time
+	}
+	clients := make([]*client, opts.Concurrency)
+	for i := range clients {
+		clients[i] = &client{c: net.Client(), rng: rand.New(rand.NewSource(opts.Seed + int64(i) + 1)), next: start}
+	}
+	nem := &nemesis{net: net, nodes: nodes, rng: rand.New(rand.NewSource(opts.Seed))}
+	nextToggle := start.Add(nemesisInterval)
+
+	interval := opInterval(opts)
+	ops := 0
+	for ctx.Err() == nil {
+		c := clients[0]
+		for _, other := range clients[1:] {
+			if other.next.Before(c.next) {
+				c = other
+			}
+		}
+		if opts.Partition && nextToggle.Before(c.next) {
+			if !nextToggle.Before(deadline) {
+				break
+			}
+			net.Sleep(nextToggle.Sub(clk.Now()))
+			nem.toggle()
+			nextToggle = nextToggle.Add(nemesisInterval)
+			continue
+		}
+		if !c.next.Before(deadline) {
+			break
+		}
+
+		net.Sleep(c.next.Sub(clk.Now()))
+		opCtx, cancel := clk.WithTimeout(ctx, min(opts.Timeout, deadline.Sub(clk.Now())))
+		w.op(opCtx, c.c, nodes[c.rng.Intn(len(nodes))], c.rng)
+		cancel()
+		ops++
+		c.next = clk.Now().Add(time.Duration(c.rng.Int63n(int64(2*interval) + 1)))
+	}
+	return ops
+}
+
+// opInterval returns the mean time between two operations of a client.
+func opInterval(opts Options) time.Duration {
+	return time.Duration(float64(opts.Concurrency) / opts.Rate * float64(time.Second))
+}
+
+// nemesisInterval is the time between two partition changes.
+const nemesisInterval = 5 * time.Second
+
+// nemesis alternates between splitting the nodes into two random halves and
+// healing them.
+type nemesis struct {
+	net   *sim.Network
+	nodes []string
+	rng   *rand.Rand
+	split bool
+}
+
+func (nem *nemesis) toggle() {
+	nem.split = !nem.split
+	if !nem.split {
+		nem.net.Heal()
+		return
+	}
+	shuffled := append([]string(nil), nem.nodes...)
+	nem.rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	half := len(shuffled) / 2
+	nem.net.Partition(shuffled[:half], shuffled[half:])
+}
+
+// outcome classifies the result of a request the way Maelstrom does:
+// timeouts and crashes are indeterminate, other errors definite.
+func outcome(err error) checker.OpType {
+	if err == nil {
+		return checker.Ok
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return checker.Info
+	}
+	switch maelstrom.ErrorCode(err) {
+	case maelstrom.Timeout, maelstrom.Crash, -1:
+		return checker.Info
+	default:
+		return checker.Fail
+	}
+}